@@ -0,0 +1,206 @@
+// Package chains is the registry of known chains: their chain ids, aliases,
+// entry point deployments and suggested indexing settings. A default registry
+// is embedded, entries of a user file or directory override it by name.
+package chains
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//go:embed chains.json
+var defaultRegistry []byte
+
+// Deployment is an entry point deployed on a chain.
+type Deployment struct {
+	Address string `json:"address"`
+	Version string `json:"version,omitempty"`
+	// Block is the deploy block, searched on chain if not set
+	Block int64 `json:"block,omitempty"`
+}
+
+// Chain is a registry entry.
+type Chain struct {
+	Name        string       `json:"name"`
+	ChainId     uint64       `json:"chainId"`
+	Aliases     []string     `json:"aliases,omitempty"`
+	EntryPoints []Deployment `json:"entryPoints"`
+	// BlockRange is the suggested eth_getLogs block range
+	BlockRange int64 `json:"blockRange,omitempty"`
+	// PollInterval is the suggested polling interval in milliseconds
+	PollInterval int64 `json:"pollInterval,omitempty"`
+}
+
+// Id returns the chain id in decimal.
+func (c *Chain) Id() string {
+	return strconv.FormatUint(c.ChainId, 10)
+}
+
+// Deployment returns the deployment of an entry point on the chain.
+func (c *Chain) Deployment(address common.Address) (Deployment, bool) {
+	for _, deployment := range c.EntryPoints {
+		if common.HexToAddress(deployment.Address) == address {
+			return deployment, true
+		}
+	}
+	return Deployment{}, false
+}
+
+var (
+	_lock   sync.RWMutex
+	_chains = map[string]*Chain{}
+	_names  = map[string]*Chain{}
+)
+
+func init() {
+	entries, err := parse(defaultRegistry)
+	if err != nil {
+		panic(fmt.Sprintf("default chain registry: %v", err))
+	}
+	if err := merge(entries); err != nil {
+		panic(fmt.Sprintf("default chain registry: %v", err))
+	}
+}
+
+// Lookup returns the chain with the given name or alias.
+func Lookup(name string) (*Chain, bool) {
+	_lock.RLock()
+	defer _lock.RUnlock()
+	chain, ok := _names[strings.ToLower(name)]
+	return chain, ok
+}
+
+// All returns the registered chains ordered by name.
+func All() []*Chain {
+	_lock.RLock()
+	defer _lock.RUnlock()
+	var result []*Chain
+	for _, chain := range _chains {
+		result = append(result, chain)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Load overrides the registry with the entries of a json file, or of every
+// json file of a directory. A file holds a single entry or a list of them,
+// an entry replaces the registered one of the same name.
+func Load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(files)
+	}
+
+	var entries []*Chain
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		fileEntries, err := parse(content)
+		if err != nil {
+			return fmt.Errorf("chain registry %s: %w", file, err)
+		}
+		entries = append(entries, fileEntries...)
+	}
+	return merge(entries)
+}
+
+func parse(content []byte) ([]*Chain, error) {
+	var entries []*Chain
+	if trimmed := strings.TrimSpace(string(content)); strings.HasPrefix(trimmed, "{") {
+		var entry *Chain
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	} else if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if err := entry.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func (c *Chain) validate() error {
+	c.Name = strings.ToLower(c.Name)
+	if c.Name == "" {
+		return fmt.Errorf("chain without name")
+	}
+	if c.ChainId == 0 {
+		return fmt.Errorf("chain %s: no chain id", c.Name)
+	}
+	for idx, alias := range c.Aliases {
+		c.Aliases[idx] = strings.ToLower(alias)
+	}
+	for idx := range c.EntryPoints {
+		deployment := &c.EntryPoints[idx]
+		if !common.IsHexAddress(deployment.Address) {
+			return fmt.Errorf("chain %s: invalid entry point address '%s'", c.Name, deployment.Address)
+		}
+		deployment.Address = strings.ToLower(deployment.Address)
+		if deployment.Version == "" {
+			continue
+		}
+		ep, err := entrypoint.Get(deployment.Version)
+		if err != nil {
+			return fmt.Errorf("chain %s: entry point %s: %w", c.Name, deployment.Address, err)
+		}
+		deployment.Version = ep.Version
+	}
+	return nil
+}
+
+// merge replaces the registered entries by name and rebuilds the name index,
+// the registry is left unchanged if a name or alias becomes ambiguous.
+func merge(entries []*Chain) error {
+	_lock.Lock()
+	defer _lock.Unlock()
+
+	chains := map[string]*Chain{}
+	for name, chain := range _chains {
+		chains[name] = chain
+	}
+	for _, entry := range entries {
+		chains[entry.Name] = entry
+	}
+
+	names := map[string]*Chain{}
+	for _, chain := range chains {
+		for _, name := range append([]string{chain.Name}, chain.Aliases...) {
+			if other, ok := names[name]; ok && other != chain {
+				return fmt.Errorf("chain name %s used by %s and %s", name, other.Name, chain.Name)
+			}
+			names[name] = chain
+		}
+	}
+
+	_chains = chains
+	_names = names
+	return nil
+}
@@ -0,0 +1,73 @@
+package chains
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestLookup(t *testing.T) {
+	for name, id := range map[string]string{
+		"ethereum": "1",
+		"Mainnet":  "1",
+		"arbitrum": "42161",
+		"base":     "8453",
+		"xdai":     "100",
+	} {
+		chain, ok := Lookup(name)
+		if !ok {
+			t.Fatalf("chain %s not registered", name)
+		}
+		if chain.Id() != id {
+			t.Fatalf("chain %s id %s, want %s", name, chain.Id(), id)
+		}
+	}
+
+	if _, ok := Lookup("polygon-mumbai"); ok {
+		t.Fatal("polygon-mumbai should not be registered")
+	}
+
+	polygon, _ := Lookup("polygon")
+	deployment, ok := polygon.Deployment(common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"))
+	if !ok || deployment.Block != 41402415 {
+		t.Fatalf("unexpected polygon deployment %+v", deployment)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.json": `{"name": "devnet", "chainId": 31337, "aliases": ["anvil"], "entryPoints": [{"address": "0x0000000071727de22e5e9d8baf0edac6f37da032", "block": 1}]}`,
+		"b.json": `[{"name": "gnosis", "chainId": 100, "blockRange": 500}]`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Load(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	devnet, ok := Lookup("anvil")
+	if !ok || devnet.Id() != "31337" || len(devnet.EntryPoints) != 1 {
+		t.Fatalf("unexpected devnet %+v", devnet)
+	}
+	gnosis, _ := Lookup("gnosis")
+	if gnosis.BlockRange != 500 {
+		t.Fatalf("gnosis block range %d, want 500", gnosis.BlockRange)
+	}
+	if _, ok := Lookup("xdai"); ok {
+		t.Fatal("replaced entry kept its aliases")
+	}
+
+	ambiguous := filepath.Join(dir, "c.json")
+	if err := os.WriteFile(ambiguous, []byte(`{"name": "other", "chainId": 1, "aliases": ["anvil"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(ambiguous); err == nil {
+		t.Fatal("expected an error for an ambiguous alias")
+	}
+}
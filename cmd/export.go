@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/indexer"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
+	"github.com/urfave/cli/v2"
+)
+
+// storeFlags are the flags of the commands working on the store only.
+var storeFlags = []cli.Flag{
+	indexer.FlagConfig,
+	indexer.FlagCompress,
+	indexer.FlagDbEngin,
+	indexer.FlagDbDataSource,
+}
+
+func exportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Write the logs stored for a chain, with their records, cursors and indexed ranges, as jsonl or csv",
+		Flags: append([]cli.Flag{
+			indexer.FlagChain,
+			indexer.FlagFromBlock,
+			indexer.FlagToBlock,
+			indexer.FlagFormat,
+			indexer.FlagOutput,
+		}, storeFlags...),
+		Action: exportApp,
+	}
+}
+
+func importCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "import",
+		Usage:     "Load an export into the store, from stdin if no file is given",
+		ArgsUsage: "[file]",
+		Flags:     append([]cli.Flag{indexer.FlagFormat}, storeFlags...),
+		Action:    importApp,
+	}
+}
+
+func exportApp(ctx *cli.Context) error {
+	chain := ctx.String(indexer.FlagChain.Name)
+	if len(chain) == 0 {
+		return fmt.Errorf("missing --%s", indexer.FlagChain.Name)
+	}
+	output := ctx.String(indexer.FlagOutput.Name)
+	format, err := indexer.ParseFormat(ctx.String(indexer.FlagFormat.Name), output)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if len(output) > 0 {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	} else {
+		log.ToStderr()
+	}
+
+	dbCfg, compress := indexer.ParseStoreConfig(ctx)
+	db := indexer.NewDb(dbCfg.Engin, dbCfg.Ds)
+	defer db.Close()
+
+	count, err := indexer.Export(db, compress, chain, blockFlag(ctx, indexer.FlagFromBlock), blockFlag(ctx, indexer.FlagToBlock), format, w)
+	if err != nil {
+		return err
+	}
+	log.Info("export done", "logs", count, "chain", chain)
+	return nil
+}
+
+func importApp(ctx *cli.Context) error {
+	input := ctx.Args().First()
+	format, err := indexer.ParseFormat(ctx.String(indexer.FlagFormat.Name), input)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if len(input) > 0 && input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	dbCfg, compress := indexer.ParseStoreConfig(ctx)
+	db := indexer.NewDb(dbCfg.Engin, dbCfg.Ds)
+	defer db.Close()
+
+	count, err := indexer.Import(db, compress, format, r)
+	if err != nil {
+		return err
+	}
+	log.Info("import done", "logs", count)
+	return nil
+}
@@ -23,13 +23,20 @@ func RootApp() *cli.App {
 			indexer.FlagChain,
 			indexer.FlagReadonly,
 			indexer.FlagChainId,
+			indexer.FlagChains,
 			indexer.FlagEntryPoint,
 			indexer.FlagBackendUrl,
+			indexer.FlagBalance,
+			indexer.FlagQuorum,
 			indexer.FlagCompress,
 			indexer.FlagDbEngin,
 			indexer.FlagDbDataSource,
 			indexer.FlagEthLogsStartBlock,
 			indexer.FlagEthLogsBlockRange,
+			indexer.FlagReorgDepth,
+			indexer.FlagFinality,
+			indexer.FlagBackfillWorkers,
+			indexer.FlagShutdownTimeout,
 		},
 		EnableBashCompletion: true,
 		Before: func(ctx *cli.Context) error {
@@ -43,6 +50,11 @@ func RootApp() *cli.App {
 			return runApp(ctx)
 		},
 		Commands: []*cli.Command{
+			backfillCommand(),
+			verifyCommand(),
+			exportCommand(),
+			importCommand(),
+			migrateCommand(),
 			{
 				Name: "version",
 				Action: func(ctx *cli.Context) error {
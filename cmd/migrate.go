@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/indexer"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
+	"github.com/urfave/cli/v2"
+)
+
+func migrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "Copy all keys of a store, logs, records and cursors, to a store of another engine and verify the copy",
+		Flags: []cli.Flag{
+			indexer.FlagConfig,
+			indexer.FlagCompress,
+			indexer.FlagFromEngine,
+			indexer.FlagFromDataSource,
+			indexer.FlagToEngine,
+			indexer.FlagToDataSource,
+		},
+		Action: migrateApp,
+	}
+}
+
+func migrateApp(ctx *cli.Context) error {
+	_, compress := indexer.ParseStoreConfig(ctx)
+
+	src := indexer.NewDb(ctx.String(indexer.FlagFromEngine.Name), ctx.String(indexer.FlagFromDataSource.Name))
+	defer src.Close()
+	dst := indexer.NewDb(ctx.String(indexer.FlagToEngine.Name), ctx.String(indexer.FlagToDataSource.Name))
+	defer dst.Close()
+
+	runCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	count, err := indexer.Migrate(runCtx, src, dst, compress)
+	if errors.Is(err, context.Canceled) {
+		return errors.New("migration interrupted, run the same command again to restart it")
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("migration done", "keys", count)
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/indexer"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
+	"github.com/urfave/cli/v2"
+)
+
+// rangeFlags are the flags of the commands working on a block range of a
+// chain.
+var rangeFlags = []cli.Flag{
+	indexer.FlagConfig,
+	indexer.FlagChain,
+	indexer.FlagChainId,
+	indexer.FlagChains,
+	indexer.FlagFromBlock,
+	indexer.FlagToBlock,
+	indexer.FlagEntryPoint,
+	indexer.FlagBackendUrl,
+	indexer.FlagBalance,
+	indexer.FlagQuorum,
+	indexer.FlagCompress,
+	indexer.FlagDbEngin,
+	indexer.FlagDbDataSource,
+	indexer.FlagEthLogsBlockRange,
+	indexer.FlagReorgDepth,
+}
+
+func backfillCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "backfill",
+		Usage:  "(Re)index a block range of a chain into the store, without moving the cursors of a running indexer",
+		Flags:  rangeFlags,
+		Action: backfillApp,
+	}
+}
+
+func verifyCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "verify",
+		Usage:  "Compare the user operations stored for a block range of a chain with the chain, from the first indexed block by default",
+		Flags:  append([]cli.Flag{indexer.FlagRepair}, rangeFlags...),
+		Action: verifyApp,
+	}
+}
+
+// parseRangeCmd returns the config, the chain and the entry points of a range
+// command.
+func parseRangeCmd(ctx *cli.Context) (*indexer.Config, string, []indexer.EntryPointCfg, error) {
+	chain := ctx.String(indexer.FlagChain.Name)
+	if len(chain) == 0 {
+		return nil, "", nil, fmt.Errorf("missing --%s", indexer.FlagChain.Name)
+	}
+
+	cfg := indexer.ParseConfig(ctx)
+
+	var eps []indexer.EntryPointCfg
+	if ctx.IsSet(indexer.FlagEntryPoint.Name) {
+		var err error
+		eps, err = indexer.ParseEntryPoints(ctx.String(indexer.FlagEntryPoint.Name))
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
+	return cfg, chain, eps, nil
+}
+
+// blockFlag returns the block of a range flag, -1 if it is not set.
+func blockFlag(ctx *cli.Context, flag *cli.Int64Flag) int64 {
+	if !ctx.IsSet(flag.Name) {
+		return -1
+	}
+	return ctx.Int64(flag.Name)
+}
+
+func backfillApp(ctx *cli.Context) error {
+	cfg, chain, eps, err := parseRangeCmd(ctx)
+	if err != nil {
+		return err
+	}
+	for _, flag := range []*cli.Int64Flag{indexer.FlagFromBlock, indexer.FlagToBlock} {
+		if !ctx.IsSet(flag.Name) {
+			return fmt.Errorf("missing --%s", flag.Name)
+		}
+	}
+
+	runCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = indexer.Backfill(runCtx, cfg, chain, ctx.Int64(indexer.FlagFromBlock.Name), ctx.Int64(indexer.FlagToBlock.Name), eps)
+	if errors.Is(err, context.Canceled) {
+		return errors.New("backfill interrupted, run the same command again to resume")
+	}
+	return err
+}
+
+func verifyApp(ctx *cli.Context) error {
+	cfg, chain, eps, err := parseRangeCmd(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.ToStderr()
+	runCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return indexer.Verify(runCtx, cfg, chain, blockFlag(ctx, indexer.FlagFromBlock), blockFlag(ctx, indexer.FlagToBlock), eps, ctx.Bool(indexer.FlagRepair.Name), os.Stdout)
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/indexer"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
 	"github.com/urfave/cli/v2"
 )
 
@@ -9,5 +14,24 @@ func runApp(ctx *cli.Context) error {
 
 	cfg := indexer.ParseConfig(ctx)
 
-	return indexer.Run(cfg)
+	runCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- indexer.Run(runCtx, cfg)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-runCtx.Done():
+	}
+
+	// a second signal kills the process
+	stop()
+	log.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	// the servers bound their own drain by the shutdown timeout, wait for
+	// them and the chains so the store isn't closed under a writer
+	return <-done
 }
@@ -0,0 +1,100 @@
+package database
+
+import "github.com/ethereum/go-ethereum/common"
+
+// Batch buffers writes until Write applies them, all or none of them. Reads
+// through a batch see its buffered writes.
+type Batch interface {
+	Has(key string) (bool, error)
+	Get(key string, compressed bool) ([]byte, error)
+	Put(key string, value []byte, compressed bool) error
+	Delete(key string) error
+	// Len is the number of buffered writes
+	Len() int
+	// Write applies the buffered writes atomically and resets the batch, a
+	// failed batch is left as it was
+	Write() error
+	Reset()
+}
+
+// BatchOp is a buffered write.
+type BatchOp struct {
+	Key        string
+	Value      []byte
+	Compressed bool
+	Delete     bool
+}
+
+type batch struct {
+	store   KVStore
+	commit  func(ops []BatchOp) error
+	ops     []BatchOp
+	pending map[string]int
+}
+
+// NewBatch returns a batch reading through store, whose writes are applied by
+// commit. commit gets the last write of each key, it must apply all of them
+// or none.
+func NewBatch(store KVStore, commit func(ops []BatchOp) error) Batch {
+	return &batch{
+		store:   store,
+		commit:  commit,
+		pending: map[string]int{},
+	}
+}
+
+func (b *batch) Has(key string) (bool, error) {
+	if idx, ok := b.pending[key]; ok {
+		return !b.ops[idx].Delete, nil
+	}
+	return b.store.Has(key)
+}
+
+func (b *batch) Get(key string, compressed bool) ([]byte, error) {
+	if idx, ok := b.pending[key]; ok {
+		if b.ops[idx].Delete {
+			return nil, nil
+		}
+		return common.CopyBytes(b.ops[idx].Value), nil
+	}
+	return b.store.Get(key, compressed)
+}
+
+func (b *batch) Put(key string, value []byte, compressed bool) error {
+	b.pending[key] = len(b.ops)
+	b.ops = append(b.ops, BatchOp{Key: key, Value: common.CopyBytes(value), Compressed: compressed})
+	return nil
+}
+
+func (b *batch) Delete(key string) error {
+	b.pending[key] = len(b.ops)
+	b.ops = append(b.ops, BatchOp{Key: key, Delete: true})
+	return nil
+}
+
+func (b *batch) Len() int {
+	return len(b.ops)
+}
+
+func (b *batch) Write() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+
+	ops := make([]BatchOp, 0, len(b.pending))
+	for idx, op := range b.ops {
+		if b.pending[op.Key] == idx {
+			ops = append(ops, op)
+		}
+	}
+	if err := b.commit(ops); err != nil {
+		return err
+	}
+	b.Reset()
+	return nil
+}
+
+func (b *batch) Reset() {
+	b.ops = nil
+	b.pending = map[string]int{}
+}
@@ -0,0 +1,100 @@
+package database_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+)
+
+func TestBatch(t *testing.T) {
+	db := memorydb.New()
+	db.Put("a", []byte("1"), false)
+	db.Put("b", []byte("2"), false)
+
+	batch := db.NewBatch()
+	batch.Put("a", []byte("3"), false)
+	batch.Delete("b")
+	batch.Put("c", []byte("4"), false)
+	batch.Put("c", []byte("5"), false)
+
+	if v, _ := batch.Get("a", false); string(v) != "3" {
+		t.Fatalf("batch get a = %s, want 3", v)
+	}
+	if ok, _ := batch.Has("b"); ok {
+		t.Fatal("batch has deleted b")
+	}
+	if v, _ := db.Get("a", false); string(v) != "1" {
+		t.Fatalf("store get a = %s before write, want 1", v)
+	}
+
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if batch.Len() != 0 {
+		t.Fatalf("batch len %d after write, want 0", batch.Len())
+	}
+	for key, want := range map[string]string{"a": "3", "b": "", "c": "5"} {
+		if v, _ := db.Get(key, false); string(v) != want {
+			t.Fatalf("store get %s = %s, want %s", key, v, want)
+		}
+	}
+}
+
+func TestBatchFailedWrite(t *testing.T) {
+	db := memorydb.New()
+	var committed []database.BatchOp
+	fail := true
+	batch := database.NewBatch(db, func(ops []database.BatchOp) error {
+		if fail {
+			return errors.New("commit failed")
+		}
+		committed = ops
+		return nil
+	})
+	batch.Put("a", []byte("1"), false)
+	batch.Put("a", []byte("2"), false)
+	batch.Put("b", []byte("3"), false)
+
+	if err := batch.Write(); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if batch.Len() != 3 {
+		t.Fatalf("batch len %d after a failed write, want 3", batch.Len())
+	}
+
+	fail = false
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if len(committed) != 2 || string(committed[0].Value) != "2" || committed[1].Key != "b" {
+		t.Fatalf("unexpected committed ops %+v", committed)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	db := memorydb.New()
+	db.Put("a", []byte("1"), false)
+	batch := db.NewBatch()
+	batch.Put("b", []byte("2"), false)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+
+	if _, err := db.Get("a", false); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("get after close: %v", err)
+	}
+	if err := db.Put("a", []byte("2"), false); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("put after close: %v", err)
+	}
+	if err := db.Iterate("", "", false, func(string, []byte) bool { return true }); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("iterate after close: %v", err)
+	}
+	if err := batch.Write(); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("batch write after close: %v", err)
+	}
+}
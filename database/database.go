@@ -1,8 +1,42 @@
 package database
 
+import "errors"
+
+// ErrClosed is returned by stores used after Close.
+var ErrClosed = errors.New("database closed")
+
 type KVStore interface {
 	Has(key string) (bool, error)
 	Get(key string, compressed bool) ([]byte, error)
 	Put(key string, value []byte, compressed bool) error
 	Delete(key string) error
+	// Iterate calls fn for every key with the given prefix that is not below
+	// start, in ascending key order, until fn returns false.
+	Iterate(prefix, start string, compressed bool, fn func(key string, value []byte) bool) error
+	// NewBatch returns a batch whose writes are applied atomically.
+	NewBatch() Batch
+	// Close flushes and closes the store, it must not be used afterwards.
+	// Closing it again is a no-op.
+	Close() error
+}
+
+// PrefixEnd returns the smallest key above all keys with the given prefix, or
+// an empty string if there is none.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// IterateLowerBound returns the first key Iterate visits.
+func IterateLowerBound(prefix, start string) string {
+	if start > prefix {
+		return start
+	}
+	return prefix
 }
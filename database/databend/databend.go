@@ -4,9 +4,9 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"sync"
 
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
 	_ "github.com/datafuselabs/databend-go"
 )
@@ -14,8 +14,8 @@ import (
 type Database struct {
 	db *sql.DB
 
-	quitLock sync.Mutex
-	quitChan chan chan error
+	closeLock sync.Mutex
+	closed    bool
 
 	log log.Logger
 }
@@ -28,33 +28,26 @@ func NewDatabendDB(dsn string) (*Database, error) {
 	logger := log.New("databend")
 
 	db := &Database{
-		db:       conn,
-		log:      logger,
-		quitChan: make(chan chan error),
+		db:  conn,
+		log: logger,
 	}
 	return db, nil
 }
 
+// Close closes the connection pool, closing it again is a no-op.
 func (d *Database) Close() error {
-	d.quitLock.Lock()
-	defer d.quitLock.Unlock()
+	d.closeLock.Lock()
+	defer d.closeLock.Unlock()
 
-	if d.quitChan == nil {
+	if d.closed {
 		return nil
 	}
-	errc := make(chan error)
-	d.quitChan <- errc
-	if err := <-errc; err != nil {
-		d.log.Error("Metrics collection failed", "err", err)
-	}
-	d.quitChan = nil
-
+	d.closed = true
 	return d.db.Close()
 }
 
 func (d *Database) Has(key string) (bool, error) {
-	query := fmt.Sprintf(`SELECT * FROM indexer WHERE key='%s'`, key)
-	row := d.db.QueryRow(query)
+	row := d.db.QueryRow(`SELECT * FROM indexer WHERE key=?`, key)
 
 	var col1, col2 string
 	err := row.Scan(&col1, &col2)
@@ -68,8 +61,7 @@ func (d *Database) Has(key string) (bool, error) {
 }
 
 func (d *Database) Get(key string, compressed bool) ([]byte, error) {
-	query := fmt.Sprintf(`SELECT * FROM indexer WHERE key='%s'`, key)
-	row := d.db.QueryRow(query)
+	row := d.db.QueryRow(`SELECT * FROM indexer WHERE key=?`, key)
 
 	var col1, col2 string
 	err := row.Scan(&col1, &col2)
@@ -93,20 +85,87 @@ func (d *Database) Get(key string, compressed bool) ([]byte, error) {
 	return result, nil
 }
 
-func (d *Database) Put(key string, value []byte, compressed bool) error {
+const (
+	_putQuery    = `REPLACE INTO indexer ON (key) VALUES (?, ?)`
+	_deleteQuery = `DELETE FROM indexer WHERE key=?`
+)
+
+func putArgs(key string, value []byte, compressed bool) []any {
 	var data string
 	if compressed {
 		data = base64.StdEncoding.EncodeToString(value)
 	} else {
 		data = string(value)
 	}
-	query := fmt.Sprintf(`REPLACE INTO indexer ON (key) VALUES ('%s', '%s')`, key, data)
-	_, err := d.db.Exec(query)
+	return []any{key, data}
+}
+
+func (d *Database) Put(key string, value []byte, compressed bool) error {
+	_, err := d.db.Exec(_putQuery, putArgs(key, value, compressed)...)
 	return err
 }
 
 func (d *Database) Delete(key string) error {
-	query := fmt.Sprintf(`DELETE FROM indexer WHERE key='%s'`, key)
-	_, err := d.db.Exec(query)
+	_, err := d.db.Exec(_deleteQuery, key)
 	return err
 }
+
+// NewBatch returns a batch applied in a single transaction.
+func (d *Database) NewBatch() database.Batch {
+	return database.NewBatch(d, func(ops []database.BatchOp) error {
+		tx, err := d.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		for _, op := range ops {
+			query, args := _deleteQuery, []any{op.Key}
+			if !op.Delete {
+				query, args = _putQuery, putArgs(op.Key, op.Value, op.Compressed)
+			}
+			if _, err := tx.Exec(query, args...); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+func (d *Database) Iterate(prefix, start string, compressed bool, fn func(key string, value []byte) bool) error {
+	query := `SELECT * FROM indexer WHERE key>=?`
+	args := []any{database.IterateLowerBound(prefix, start)}
+	if end := database.PrefixEnd(prefix); len(end) > 0 {
+		query += ` AND key<?`
+		args = append(args, end)
+	}
+	query += ` ORDER BY key`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var col1, col2 string
+		if err := rows.Scan(&col1, &col2); err != nil {
+			return err
+		}
+
+		var value []byte
+		if compressed {
+			value, err = base64.StdEncoding.DecodeString(col2)
+			if err != nil {
+				value = []byte(col2)
+			}
+		} else {
+			value = []byte(col2)
+		}
+
+		if !fn(col1, value) {
+			break
+		}
+	}
+	return rows.Err()
+}
@@ -1,14 +1,18 @@
 package memorydb
 
 import (
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
 	"github.com/ethereum/go-ethereum/common"
 )
 
 type Database struct {
-	db   map[string][]byte
-	lock sync.RWMutex
+	db     map[string][]byte
+	closed bool
+	lock   sync.RWMutex
 }
 
 func New() *Database {
@@ -21,7 +25,7 @@ func (db *Database) Close() error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	db.db = nil
+	db.closed = true
 	return nil
 }
 
@@ -29,6 +33,9 @@ func (db *Database) Has(key string) (bool, error) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
+	if db.closed {
+		return false, database.ErrClosed
+	}
 	_, ok := db.db[key]
 	return ok, nil
 }
@@ -37,6 +44,9 @@ func (db *Database) Get(key string, compressed bool) ([]byte, error) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
+	if db.closed {
+		return nil, database.ErrClosed
+	}
 	if entry, ok := db.db[key]; ok {
 		return common.CopyBytes(entry), nil
 	}
@@ -48,6 +58,9 @@ func (db *Database) Put(key string, value []byte, compressed bool) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
+	if db.closed {
+		return database.ErrClosed
+	}
 	db.db[key] = common.CopyBytes(value)
 	return nil
 }
@@ -56,6 +69,57 @@ func (db *Database) Delete(key string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
+	if db.closed {
+		return database.ErrClosed
+	}
 	delete(db.db, key)
 	return nil
 }
+
+func (db *Database) Iterate(prefix, start string, compressed bool, fn func(key string, value []byte) bool) error {
+	lower := database.IterateLowerBound(prefix, start)
+
+	db.lock.RLock()
+	if db.closed {
+		db.lock.RUnlock()
+		return database.ErrClosed
+	}
+	var keys []string
+	for key := range db.db {
+		if strings.HasPrefix(key, prefix) && key >= lower {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = common.CopyBytes(db.db[key])
+	}
+	db.lock.RUnlock()
+
+	for i, key := range keys {
+		if !fn(key, values[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (db *Database) NewBatch() database.Batch {
+	return database.NewBatch(db, func(ops []database.BatchOp) error {
+		db.lock.Lock()
+		defer db.lock.Unlock()
+
+		if db.closed {
+			return database.ErrClosed
+		}
+		for _, op := range ops {
+			if op.Delete {
+				delete(db.db, op.Key)
+			} else {
+				db.db[op.Key] = common.CopyBytes(op.Value)
+			}
+		}
+		return nil
+	})
+}
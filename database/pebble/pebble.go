@@ -4,6 +4,7 @@ import (
 	"runtime"
 	"sync"
 
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/bloom"
 	"github.com/ethereum/go-ethereum/common"
@@ -19,8 +20,9 @@ type Database struct {
 	fn string     // filename for reporting
 	db *pebble.DB // Underlying pebble storage engine
 
-	quitLock sync.Mutex      // Mutex protecting the quit channel access
-	quitChan chan chan error // Quit channel to stop the metrics collection before closing the database
+	closeLock sync.RWMutex   // Mutex protecting the closed flag
+	closed    bool           // Whether the database has been closed
+	active    sync.WaitGroup // Operations Close waits for
 
 	log log.Logger // Contextual logger tracking the database path
 }
@@ -51,9 +53,8 @@ func NewPebbleDb(file string, cache int, handles int, readonly bool) (*Database,
 		memTableSize = maxMemTableSize
 	}
 	db := &Database{
-		fn:       file,
-		log:      logger,
-		quitChan: make(chan chan error),
+		fn:  file,
+		log: logger,
 	}
 	opt := &pebble.Options{
 		// Pebble has a single combined cache area and the write
@@ -104,24 +105,47 @@ func NewPebbleDb(file string, cache int, handles int, readonly bool) (*Database,
 	return db, nil
 }
 
+// Close flushes the memtables and closes the database once the running
+// operations are done, closing it again is a no-op.
 func (d *Database) Close() error {
-	d.quitLock.Lock()
-	defer d.quitLock.Unlock()
-
-	if d.quitChan == nil {
+	d.closeLock.Lock()
+	if d.closed {
+		d.closeLock.Unlock()
 		return nil
 	}
-	errc := make(chan error)
-	d.quitChan <- errc
-	if err := <-errc; err != nil {
-		d.log.Error("Metrics collection failed", "err", err)
-	}
-	d.quitChan = nil
+	d.closed = true
+	d.closeLock.Unlock()
+	d.active.Wait()
 
+	if err := d.db.Flush(); err != nil {
+		d.log.Error("Flush failed", "err", err)
+	}
 	return d.db.Close()
 }
 
+// acquire registers an operation, it returns false once the database is
+// closed. An operation calls release when it is done with the database.
+func (d *Database) acquire() bool {
+	d.closeLock.RLock()
+	defer d.closeLock.RUnlock()
+
+	if d.closed {
+		return false
+	}
+	d.active.Add(1)
+	return true
+}
+
+func (d *Database) release() {
+	d.active.Done()
+}
+
 func (d *Database) Has(key string) (bool, error) {
+	if !d.acquire() {
+		return false, database.ErrClosed
+	}
+	defer d.release()
+
 	_, closer, err := d.db.Get([]byte(key))
 
 	if err == pebble.ErrNotFound {
@@ -134,6 +158,11 @@ func (d *Database) Has(key string) (bool, error) {
 }
 
 func (d *Database) Get(key string, compressed bool) ([]byte, error) {
+	if !d.acquire() {
+		return nil, database.ErrClosed
+	}
+	defer d.release()
+
 	dat, closer, err := d.db.Get([]byte(key))
 	if err != nil {
 		if err == pebble.ErrNotFound {
@@ -148,9 +177,73 @@ func (d *Database) Get(key string, compressed bool) ([]byte, error) {
 }
 
 func (d *Database) Put(key string, value []byte, compressed bool) error {
-	return d.db.Set([]byte(key), value, pebble.NoSync)
+	if !d.acquire() {
+		return database.ErrClosed
+	}
+	defer d.release()
+
+	return d.db.Set([]byte(key), value, pebble.Sync)
 }
 
 func (d *Database) Delete(key string) error {
-	return d.db.Delete([]byte(key), nil)
+	if !d.acquire() {
+		return database.ErrClosed
+	}
+	defer d.release()
+
+	return d.db.Delete([]byte(key), pebble.Sync)
+}
+
+func (d *Database) Iterate(prefix, start string, compressed bool, fn func(key string, value []byte) bool) error {
+	if !d.acquire() {
+		return database.ErrClosed
+	}
+	defer d.release()
+
+	opt := &pebble.IterOptions{
+		LowerBound: []byte(database.IterateLowerBound(prefix, start)),
+	}
+	if end := database.PrefixEnd(prefix); len(end) > 0 {
+		opt.UpperBound = []byte(end)
+	}
+
+	iter, err := d.db.NewIter(opt)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		value := common.CopyBytes(iter.Value())
+		if !fn(string(iter.Key()), value) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// NewBatch returns a batch committed with a synced write ahead log.
+func (d *Database) NewBatch() database.Batch {
+	return database.NewBatch(d, func(ops []database.BatchOp) error {
+		if !d.acquire() {
+			return database.ErrClosed
+		}
+		defer d.release()
+
+		batch := d.db.NewBatch()
+		defer batch.Close()
+
+		for _, op := range ops {
+			var err error
+			if op.Delete {
+				err = batch.Delete([]byte(op.Key), nil)
+			} else {
+				err = batch.Set([]byte(op.Key), op.Value, nil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return batch.Commit(pebble.Sync)
+	})
 }
@@ -0,0 +1,65 @@
+package pebble_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/pebble"
+)
+
+func TestClosed(t *testing.T) {
+	db, err := pebble.NewPebbleDb(t.TempDir(), 16, 16, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put("a", []byte("1"), false)
+	db.Put("b", []byte("2"), false)
+
+	// Close waits for a running iteration, which may still use the database
+	iterating, closed := make(chan struct{}), make(chan error)
+	go func() {
+		<-iterating
+		closed <- db.Close()
+	}()
+	var gets []error
+	err = db.Iterate("", "", false, func(key string, value []byte) bool {
+		if key == "a" {
+			close(iterating)
+			time.Sleep(50 * time.Millisecond)
+		}
+		_, err := db.Get(key, false)
+		gets = append(gets, err)
+		return true
+	})
+	if err != nil || len(gets) != 2 {
+		t.Fatalf("iterate during close = %v, %v keys", err, len(gets))
+	}
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close did not return after the iteration")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+	if _, err := db.Get("a", false); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("get after close: %v", err)
+	}
+	if err := db.Put("a", []byte("2"), false); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("put after close: %v", err)
+	}
+	if err := db.Iterate("", "", false, func(string, []byte) bool { return true }); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("iterate after close: %v", err)
+	}
+	batch := db.NewBatch()
+	batch.Put("c", []byte("3"), false)
+	if err := batch.Write(); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("batch write after close: %v", err)
+	}
+}
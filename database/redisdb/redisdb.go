@@ -2,21 +2,37 @@ package redisdb
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 	"sync"
 	"time"
 
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/redis/go-redis/v9"
 )
 
+// keysIndex is a sorted set of all keys, redis has no ordered key scan.
+// keysIndexed is set once the keys written before the index existed have been
+// added to it.
+const (
+	keysIndex         = "keys-index"
+	keysIndexed       = "keys-indexed"
+	iterateBatchCount = 256
+	scanBatchCount    = 1000
+)
+
 type Database struct {
-	db   *redis.Client
-	lock sync.RWMutex
+	db     *redis.Client
+	closed bool
+	lock   sync.RWMutex
 }
 
-func NewDatabase(dataSource string) *Database {
-	ds, _ := url.Parse(dataSource)
+func NewDatabase(dataSource string) (*Database, error) {
+	ds, err := url.Parse(dataSource)
+	if err != nil {
+		return nil, err
+	}
 	passwd, _ := ds.User.Password()
 
 	cli := redis.NewClient(&redis.Options{
@@ -42,18 +58,60 @@ func NewDatabase(dataSource string) *Database {
 			return nil
 		},
 	})
-	return &Database{
+	db := &Database{
 		db: cli,
 	}
+	if err := db.indexKeys(context.Background()); err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("error index keys: %w", err)
+	}
+	return db, nil
+}
+
+// indexKeys adds the keys written before the keys index existed to it, so
+// Iterate visits them. It scans the whole database once.
+func (db *Database) indexKeys(ctx context.Context) error {
+	done, err := db.db.Exists(ctx, keysIndexed).Result()
+	if err != nil || done == 1 {
+		return err
+	}
+
+	var cursor uint64
+	var count int
+	for {
+		keys, next, err := db.db.Scan(ctx, cursor, "*", scanBatchCount).Result()
+		if err != nil {
+			return err
+		}
+		var members []redis.Z
+		for _, key := range keys {
+			if key != keysIndex && key != keysIndexed {
+				members = append(members, redis.Z{Member: key})
+			}
+		}
+		if len(members) > 0 {
+			if err := db.db.ZAdd(ctx, keysIndex, members...).Err(); err != nil {
+				return err
+			}
+			count += len(members)
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	log.Info("indexed redis keys", "count", count)
+	return db.db.Set(ctx, keysIndexed, "1", 0).Err()
 }
 
 func (db *Database) Close() error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
-	db.db.Close()
-
-	db.db = nil
-	return nil
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	return db.db.Close()
 }
 
 // Has retrieves if a key is present in the key-value store.
@@ -61,6 +119,9 @@ func (db *Database) Has(key string) (bool, error) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
+	if db.closed {
+		return false, database.ErrClosed
+	}
 	v, err := db.db.Exists(context.Background(), key).Result()
 	return v == 1, err
 }
@@ -70,6 +131,9 @@ func (db *Database) Get(key string, compressed bool) ([]byte, error) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
+	if db.closed {
+		return nil, database.ErrClosed
+	}
 	v, err := db.db.Get(context.Background(), key).Bytes()
 	if err == redis.Nil {
 		return nil, nil
@@ -83,7 +147,14 @@ func (db *Database) Put(key string, value []byte, compressed bool) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	err := db.db.Set(context.Background(), key, value, 0).Err()
+	if db.closed {
+		return database.ErrClosed
+	}
+	_, err := db.db.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.Set(context.Background(), key, value, 0)
+		pipe.ZAdd(context.Background(), keysIndex, redis.Z{Member: key})
+		return nil
+	})
 
 	return err
 }
@@ -93,7 +164,90 @@ func (db *Database) Delete(key string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	err := db.db.Del(context.Background(), key).Err()
+	if db.closed {
+		return database.ErrClosed
+	}
+	_, err := db.db.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.Del(context.Background(), key)
+		pipe.ZRem(context.Background(), keysIndex, key)
+		return nil
+	})
 
 	return err
 }
+
+// NewBatch returns a batch applied in a single MULTI/EXEC transaction.
+func (db *Database) NewBatch() database.Batch {
+	return database.NewBatch(db, func(ops []database.BatchOp) error {
+		db.lock.Lock()
+		defer db.lock.Unlock()
+
+		if db.closed {
+			return database.ErrClosed
+		}
+		_, err := db.db.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+			for _, op := range ops {
+				if op.Delete {
+					pipe.Del(context.Background(), op.Key)
+					pipe.ZRem(context.Background(), keysIndex, op.Key)
+				} else {
+					pipe.Set(context.Background(), op.Key, op.Value, 0)
+					pipe.ZAdd(context.Background(), keysIndex, redis.Z{Member: op.Key})
+				}
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+// Iterate walks the keys with the given prefix in the keys index. fn is called
+// without holding the lock, so it may use the database.
+func (db *Database) Iterate(prefix, start string, compressed bool, fn func(key string, value []byte) bool) error {
+	opt := &redis.ZRangeBy{
+		Min:   "[" + database.IterateLowerBound(prefix, start),
+		Max:   "+",
+		Count: iterateBatchCount,
+	}
+	if end := database.PrefixEnd(prefix); len(end) > 0 {
+		opt.Max = "(" + end
+	}
+
+	for {
+		keys, values, err := db.iterateBatch(opt)
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+		for i, key := range keys {
+			value, ok := values[i].(string)
+			if !ok {
+				continue
+			}
+			if !fn(key, []byte(value)) {
+				return nil
+			}
+		}
+
+		if len(keys) < iterateBatchCount {
+			return nil
+		}
+		opt.Min = "(" + keys[len(keys)-1]
+	}
+}
+
+// iterateBatch reads the next keys of an iteration with their values.
+func (db *Database) iterateBatch(opt *redis.ZRangeBy) ([]string, []any, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if db.closed {
+		return nil, nil, database.ErrClosed
+	}
+	ctx := context.Background()
+	keys, err := db.db.ZRangeByLex(ctx, keysIndex, opt).Result()
+	if err != nil || len(keys) == 0 {
+		return nil, nil, err
+	}
+	values, err := db.db.MGet(ctx, keys...).Result()
+	return keys, values, err
+}
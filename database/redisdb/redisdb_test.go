@@ -0,0 +1,79 @@
+package redisdb_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/redisdb"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func keys(t *testing.T, db *redisdb.Database, prefix string) []string {
+	var keys []string
+	err := db.Iterate(prefix, "", false, func(key string, value []byte) bool {
+		keys = append(keys, key+"="+string(value))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestIterateUnindexedKeys(t *testing.T) {
+	server := miniredis.RunT(t)
+	// written before the keys index existed
+	server.Set("chain:op:b", "2")
+	server.Set("chain:op:a", "1")
+	server.Set("other", "3")
+
+	db, err := redisdb.NewDatabase("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Put("chain:op:c", []byte("4"), false)
+
+	if got := fmt.Sprint(keys(t, db, "chain:op:")); got != "[chain:op:a=1 chain:op:b=2 chain:op:c=4]" {
+		t.Fatalf("keys %s", got)
+	}
+
+	// the database is scanned once
+	server.Set("chain:op:d", "5")
+	again, err := redisdb.NewDatabase("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if got := fmt.Sprint(keys(t, again, "chain:")); got != "[chain:op:a=1 chain:op:b=2 chain:op:c=4]" {
+		t.Fatalf("keys after reopen %s", got)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	server := miniredis.RunT(t)
+	db, err := redisdb.NewDatabase("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := db.NewBatch()
+	batch.Put("a", []byte("1"), false)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+
+	if _, err := db.Get("a", false); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("get after close: %v", err)
+	}
+	if err := db.Iterate("", "", false, func(string, []byte) bool { return true }); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("iterate after close: %v", err)
+	}
+	if err := batch.Write(); !errors.Is(err, database.ErrClosed) {
+		t.Fatalf("batch write after close: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+// Package entrypoint describes the ERC-4337 EntryPoint versions: their
+// canonical deployments, ABIs, bundle calldata layouts and user operation hash
+// formulas.
+package entrypoint
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	V06 = "v0.6"
+	V07 = "v0.7"
+	V08 = "v0.8"
+)
+
+// EntryPoint is a version of the EntryPoint contract.
+type EntryPoint struct {
+	Version string
+	// Addresses are the canonical deployments, the same on every chain
+	Addresses []common.Address
+	// Abi holds the events and the bundle methods
+	Abi abi.ABI
+	// Packed tells whether handleOps takes packed user operations (v0.7+)
+	Packed bool
+	// UserOpHash computes the hash the entry point identifies a user
+	// operation by
+	UserOpHash func(op *UserOperation, entryPoint common.Address, chainId *big.Int) common.Hash
+}
+
+var (
+	_versions  = map[string]*EntryPoint{}
+	_addresses = map[common.Address]*EntryPoint{}
+)
+
+func init() {
+	Register(&EntryPoint{
+		Version:    V06,
+		Addresses:  []common.Address{common.HexToAddress("0x5ff137d4b0fdcd49dca30c7cf57e578a026d2789")},
+		Abi:        mustParseAbi(eventsAbiV06, methodsAbiV06),
+		UserOpHash: userOpHashV06,
+	})
+	Register(&EntryPoint{
+		Version:    V07,
+		Addresses:  []common.Address{common.HexToAddress("0x0000000071727de22e5e9d8baf0edac6f37da032")},
+		Abi:        mustParseAbi(eventsAbiV07, methodsAbiV07),
+		Packed:     true,
+		UserOpHash: userOpHashV07,
+	})
+	Register(&EntryPoint{
+		Version:    V08,
+		Addresses:  []common.Address{common.HexToAddress("0x4337084d9e255ff0702461cf8895ce9e3b5ff108")},
+		Abi:        mustParseAbi(eventsAbiV08, methodsAbiV07),
+		Packed:     true,
+		UserOpHash: userOpHashV08,
+	})
+}
+
+func mustParseAbi(fragments ...string) abi.ABI {
+	result, err := abi.JSON(strings.NewReader("[" + strings.Join(fragments, ",") + "]"))
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// Register adds an entry point version, it panics if the version or one of
+// its addresses is already registered.
+func Register(ep *EntryPoint) {
+	if _, ok := _versions[ep.Version]; ok {
+		panic(fmt.Sprintf("entry point %s already registered", ep.Version))
+	}
+	for _, address := range ep.Addresses {
+		if _, ok := _addresses[address]; ok {
+			panic(fmt.Sprintf("entry point address %s already registered", address.Hex()))
+		}
+	}
+
+	_versions[ep.Version] = ep
+	for _, address := range ep.Addresses {
+		_addresses[address] = ep
+	}
+}
+
+// Get returns a registered version, accepting "v0.7" as well as "0.7".
+func Get(version string) (*EntryPoint, error) {
+	version = strings.ToLower(strings.TrimSpace(version))
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	ep, ok := _versions[version]
+	if !ok {
+		return nil, fmt.Errorf("invalid entry point version '%s', allowed %s", version, strings.Join(Versions(), ", "))
+	}
+	return ep, nil
+}
+
+// Lookup returns the version of a canonical entry point deployment.
+func Lookup(address common.Address) (*EntryPoint, bool) {
+	ep, ok := _addresses[address]
+	return ep, ok
+}
+
+// Versions returns the registered versions, oldest first.
+func Versions() []string {
+	var versions []string
+	for version := range _versions {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// Events returns the events of all registered versions, events shared by
+// several versions only once.
+func Events() map[string]abi.Event {
+	events := map[string]abi.Event{}
+	for _, version := range Versions() {
+		for name, event := range _versions[version].Abi.Events {
+			events[name] = event
+		}
+	}
+	return events
+}
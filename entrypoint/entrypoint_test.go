@@ -0,0 +1,275 @@
+package entrypoint
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+var (
+	testSender     = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testPaymaster  = common.HexToAddress("0x3333333333333333333333333333333333333333")
+	testFactory    = common.HexToAddress("0x4444444444444444444444444444444444444444")
+	testEntryPoint = common.HexToAddress("0x5555555555555555555555555555555555555555")
+	testChainId    = big.NewInt(137)
+)
+
+func mustGet(t *testing.T, version string) *EntryPoint {
+	ep, err := Get(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ep
+}
+
+func testPackedUserOperation() packedUserOperation {
+	var accountGasLimits, gasFees [32]byte
+	big.NewInt(200000).FillBytes(accountGasLimits[:16])
+	big.NewInt(100000).FillBytes(accountGasLimits[16:])
+	big.NewInt(1e9).FillBytes(gasFees[:16])
+	big.NewInt(3e9).FillBytes(gasFees[16:])
+
+	paymasterAndData := append(testPaymaster.Bytes(), make([]byte, 32)...)
+	big.NewInt(60000).FillBytes(paymasterAndData[20:36])
+	big.NewInt(40000).FillBytes(paymasterAndData[36:52])
+	paymasterAndData = append(paymasterAndData, 0xbe, 0xef)
+
+	return packedUserOperation{
+		Sender:             testSender,
+		Nonce:              big.NewInt(1),
+		InitCode:           append(testFactory.Bytes(), 0xaa),
+		CallData:           []byte{0xde, 0xad},
+		AccountGasLimits:   accountGasLimits,
+		PreVerificationGas: big.NewInt(50000),
+		GasFees:            gasFees,
+		PaymasterAndData:   paymasterAndData,
+		Signature:          []byte{0x01},
+	}
+}
+
+func TestGet(t *testing.T) {
+	for _, version := range []string{"v0.6", "0.7", " V0.8 "} {
+		if _, err := Get(version); err != nil {
+			t.Errorf("Get(%q): %v", version, err)
+		}
+	}
+	if _, err := Get("v0.5"); err == nil {
+		t.Error("expected error for v0.5")
+	}
+	if ep, ok := Lookup(common.HexToAddress("0x0000000071727De22E5E9d8BAf0edAc6f37da032")); !ok || ep.Version != V07 {
+		t.Errorf("Lookup v0.7 address: %v %v", ep, ok)
+	}
+}
+
+func TestDecodeBundleV06(t *testing.T) {
+	op := userOperationV06{
+		Sender:               testSender,
+		Nonce:                big.NewInt(7),
+		InitCode:             []byte{},
+		CallData:             []byte{0xde, 0xad},
+		CallGasLimit:         big.NewInt(100000),
+		VerificationGasLimit: big.NewInt(200000),
+		PreVerificationGas:   big.NewInt(50000),
+		MaxFeePerGas:         big.NewInt(3e9),
+		MaxPriorityFeePerGas: big.NewInt(1e9),
+		PaymasterAndData:     []byte{},
+		Signature:            []byte{0x01},
+	}
+	ep := mustGet(t, V06)
+	method := ep.Abi.Methods["handleOps"]
+	beneficiary := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	args, err := method.Inputs.Pack([]userOperationV06{op}, beneficiary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops, got, err := ep.DecodeBundle(append(method.ID, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != beneficiary {
+		t.Errorf("beneficiary %s", got.Hex())
+	}
+	if len(ops) != 1 || ops[0].Sender != testSender || ops[0].Nonce.ToInt().Int64() != 7 || ops[0].CallGasLimit.ToInt().Int64() != 100000 {
+		t.Fatalf("user operation not decoded: %+v", ops)
+	}
+
+	if _, _, err := mustGet(t, V07).DecodeBundle(append(method.ID, args...)); err == nil {
+		t.Error("v0.7 decoded a v0.6 bundle")
+	}
+}
+
+func TestDecodeBundleV07Aggregated(t *testing.T) {
+	ep := mustGet(t, V07)
+	method := ep.Abi.Methods["handleAggregatedOps"]
+	args, err := method.Inputs.Pack([]packedUserOpsPerAggregator{{UserOps: []packedUserOperation{testPackedUserOperation()}, Signature: []byte{}}}, common.Address{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops, _, err := ep.DecodeBundle(append(method.ID, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 {
+		t.Fatalf("got %d ops, want 1", len(ops))
+	}
+	got := ops[0]
+	if got.VerificationGasLimit.ToInt().Int64() != 200000 || got.CallGasLimit.ToInt().Int64() != 100000 {
+		t.Errorf("gas limits %v %v", got.VerificationGasLimit, got.CallGasLimit)
+	}
+	if got.MaxPriorityFeePerGas.ToInt().Int64() != 1e9 || got.MaxFeePerGas.ToInt().Int64() != 3e9 {
+		t.Errorf("gas fees %v %v", got.MaxPriorityFeePerGas, got.MaxFeePerGas)
+	}
+	if got.Factory == nil || *got.Factory != testFactory || len(*got.FactoryData) != 1 {
+		t.Errorf("factory %v %v", got.Factory, got.FactoryData)
+	}
+	if got.Paymaster == nil || *got.Paymaster != testPaymaster || got.PaymasterPostOpGasLimit.ToInt().Int64() != 40000 || len(*got.PaymasterData) != 2 {
+		t.Errorf("paymaster %v %v %v", got.Paymaster, got.PaymasterPostOpGasLimit, got.PaymasterData)
+	}
+
+	packed := got.pack()
+	want := testPackedUserOperation()
+	if hexutil.Encode(packed.InitCode) != hexutil.Encode(want.InitCode) || hexutil.Encode(packed.PaymasterAndData) != hexutil.Encode(want.PaymasterAndData) ||
+		packed.AccountGasLimits != want.AccountGasLimits || packed.GasFees != want.GasFees {
+		t.Errorf("pack does not round trip: %+v", packed)
+	}
+}
+
+func mustArguments(t *testing.T, types ...string) abi.Arguments {
+	var args abi.Arguments
+	for _, name := range types {
+		typ, err := abi.NewType(name, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, abi.Argument{Type: typ})
+	}
+	return args
+}
+
+func TestUserOpHashV06(t *testing.T) {
+	initCode, paymasterAndData := hexutil.Bytes{}, hexutil.Bytes(testPaymaster.Bytes())
+	op := &UserOperation{
+		Sender:               testSender,
+		Nonce:                (*hexutil.Big)(big.NewInt(7)),
+		InitCode:             &initCode,
+		CallData:             []byte{0xde, 0xad},
+		CallGasLimit:         (*hexutil.Big)(big.NewInt(100000)),
+		VerificationGasLimit: (*hexutil.Big)(big.NewInt(200000)),
+		PreVerificationGas:   (*hexutil.Big)(big.NewInt(50000)),
+		MaxFeePerGas:         (*hexutil.Big)(big.NewInt(3e9)),
+		MaxPriorityFeePerGas: (*hexutil.Big)(big.NewInt(1e9)),
+		PaymasterAndData:     &paymasterAndData,
+	}
+
+	packed, err := mustArguments(t, "address", "uint256", "bytes32", "bytes32", "uint256", "uint256", "uint256", "uint256", "uint256", "bytes32").Pack(
+		testSender, big.NewInt(7), crypto.Keccak256Hash(nil), crypto.Keccak256Hash([]byte{0xde, 0xad}),
+		big.NewInt(100000), big.NewInt(200000), big.NewInt(50000), big.NewInt(3e9), big.NewInt(1e9), crypto.Keccak256Hash(testPaymaster.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := mustArguments(t, "bytes32", "address", "uint256").Pack(crypto.Keccak256Hash(packed), testEntryPoint, testChainId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := crypto.Keccak256Hash(encoded)
+	if got := mustGet(t, V06).UserOpHash(op, testEntryPoint, testChainId); got != want {
+		t.Errorf("got %s, want %s", got.Hex(), want.Hex())
+	}
+}
+
+func testUnpackedUserOperation(t *testing.T) (*UserOperation, packedUserOperation) {
+	ep := mustGet(t, V07)
+	method := ep.Abi.Methods["handleOps"]
+	op := testPackedUserOperation()
+	args, err := method.Inputs.Pack([]packedUserOperation{op}, common.Address{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, _, err := ep.DecodeBundle(append(method.ID, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ops[0], op
+}
+
+func TestUserOpHashV07(t *testing.T) {
+	unpacked, op := testUnpackedUserOperation(t)
+
+	packed, err := mustArguments(t, "address", "uint256", "bytes32", "bytes32", "bytes32", "uint256", "bytes32", "bytes32").Pack(
+		op.Sender, op.Nonce, crypto.Keccak256Hash(op.InitCode), crypto.Keccak256Hash(op.CallData),
+		op.AccountGasLimits, op.PreVerificationGas, op.GasFees, crypto.Keccak256Hash(op.PaymasterAndData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := mustArguments(t, "bytes32", "address", "uint256").Pack(crypto.Keccak256Hash(packed), testEntryPoint, testChainId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := crypto.Keccak256Hash(encoded)
+	if got := mustGet(t, V07).UserOpHash(unpacked, testEntryPoint, testChainId); got != want {
+		t.Errorf("got %s, want %s", got.Hex(), want.Hex())
+	}
+}
+
+func TestUserOpHashV08(t *testing.T) {
+	unpacked, op := testUnpackedUserOperation(t)
+
+	typedData := apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"PackedUserOperation": {
+				{Name: "sender", Type: "address"},
+				{Name: "nonce", Type: "uint256"},
+				{Name: "initCode", Type: "bytes"},
+				{Name: "callData", Type: "bytes"},
+				{Name: "accountGasLimits", Type: "bytes32"},
+				{Name: "preVerificationGas", Type: "uint256"},
+				{Name: "gasFees", Type: "bytes32"},
+				{Name: "paymasterAndData", Type: "bytes"},
+			},
+		},
+		PrimaryType: "PackedUserOperation",
+		Domain: apitypes.TypedDataDomain{
+			Name:              "ERC4337",
+			Version:           "1",
+			ChainId:           (*math.HexOrDecimal256)(testChainId),
+			VerifyingContract: testEntryPoint.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"sender":             op.Sender.Hex(),
+			"nonce":              op.Nonce.String(),
+			"initCode":           hexutil.Encode(op.InitCode),
+			"callData":           hexutil.Encode(op.CallData),
+			"accountGasLimits":   hexutil.Encode(op.AccountGasLimits[:]),
+			"preVerificationGas": op.PreVerificationGas.String(),
+			"gasFees":            hexutil.Encode(op.GasFees[:]),
+			"paymasterAndData":   hexutil.Encode(op.PaymasterAndData),
+		},
+	}
+	want, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := mustGet(t, V08).UserOpHash(unpacked, testEntryPoint, testChainId); got != common.BytesToHash(want) {
+		t.Errorf("got %s, want %s", got.Hex(), hexutil.Encode(want))
+	}
+	if mustGet(t, V07).UserOpHash(unpacked, testEntryPoint, testChainId) == common.BytesToHash(want) {
+		t.Error("v0.7 and v0.8 hashes must differ")
+	}
+}
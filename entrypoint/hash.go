@@ -0,0 +1,86 @@
+package entrypoint
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	_eip712DomainTypeHash = crypto.Keccak256Hash([]byte("EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)"))
+	_packedUserOpTypeHash = crypto.Keccak256Hash([]byte("PackedUserOperation(address sender,uint256 nonce,bytes initCode,bytes callData,bytes32 accountGasLimits,uint256 preVerificationGas,bytes32 gasFees,bytes paymasterAndData)"))
+	_eip712DomainName     = crypto.Keccak256Hash([]byte("ERC4337"))
+	_eip712DomainVersion  = crypto.Keccak256Hash([]byte("1"))
+)
+
+// encode abi encodes static values, each left padded to a 32 bytes word.
+func encode(values ...[]byte) []byte {
+	result := make([]byte, 0, len(values)*32)
+	for _, value := range values {
+		result = append(result, common.LeftPadBytes(value, 32)...)
+	}
+	return result
+}
+
+func uint256(value *hexutil.Big) []byte {
+	if value == nil {
+		return nil
+	}
+	return value.ToInt().Bytes()
+}
+
+// userOpHashV06 is keccak256(abi.encode(keccak256(pack(op)), entryPoint, chainId))
+// over the v0.6 user operation fields.
+func userOpHashV06(op *UserOperation, entryPoint common.Address, chainId *big.Int) common.Hash {
+	packed := encode(
+		op.Sender.Bytes(),
+		uint256(op.Nonce),
+		crypto.Keccak256(bytesOf(op.InitCode)),
+		crypto.Keccak256(op.CallData),
+		uint256(op.CallGasLimit),
+		uint256(op.VerificationGasLimit),
+		uint256(op.PreVerificationGas),
+		uint256(op.MaxFeePerGas),
+		uint256(op.MaxPriorityFeePerGas),
+		crypto.Keccak256(bytesOf(op.PaymasterAndData)),
+	)
+	return crypto.Keccak256Hash(encode(crypto.Keccak256(packed), entryPoint.Bytes(), chainId.Bytes()))
+}
+
+// packedFields are the hashed fields of a packed user operation.
+func packedFields(op *UserOperation) [][]byte {
+	packed := op.pack()
+	return [][]byte{
+		packed.Sender.Bytes(),
+		packed.Nonce.Bytes(),
+		crypto.Keccak256(packed.InitCode),
+		crypto.Keccak256(packed.CallData),
+		packed.AccountGasLimits[:],
+		packed.PreVerificationGas.Bytes(),
+		packed.GasFees[:],
+		crypto.Keccak256(packed.PaymasterAndData),
+	}
+}
+
+// userOpHashV07 is the v0.6 formula over the packed user operation fields.
+func userOpHashV07(op *UserOperation, entryPoint common.Address, chainId *big.Int) common.Hash {
+	packed := encode(packedFields(op)...)
+	return crypto.Keccak256Hash(encode(crypto.Keccak256(packed), entryPoint.Bytes(), chainId.Bytes()))
+}
+
+// userOpHashV08 is the EIP-712 typed data hash of the packed user operation.
+// EIP-7702 user operations hash the delegate of the sender in place of the
+// initCode marker, which can't be computed from the calldata alone.
+func userOpHashV08(op *UserOperation, entryPoint common.Address, chainId *big.Int) common.Hash {
+	domainSeparator := crypto.Keccak256(encode(
+		_eip712DomainTypeHash.Bytes(),
+		_eip712DomainName.Bytes(),
+		_eip712DomainVersion.Bytes(),
+		chainId.Bytes(),
+		entryPoint.Bytes(),
+	))
+	structHash := crypto.Keccak256(encode(append([][]byte{_packedUserOpTypeHash.Bytes()}, packedFields(op)...)...))
+	return crypto.Keccak256Hash([]byte{0x19, 0x01}, domainSeparator, structHash)
+}
@@ -0,0 +1,208 @@
+package entrypoint
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type userOperationV06 struct {
+	Sender               common.Address
+	Nonce                *big.Int
+	InitCode             []byte
+	CallData             []byte
+	CallGasLimit         *big.Int
+	VerificationGasLimit *big.Int
+	PreVerificationGas   *big.Int
+	MaxFeePerGas         *big.Int
+	MaxPriorityFeePerGas *big.Int
+	PaymasterAndData     []byte
+	Signature            []byte
+}
+
+type packedUserOperation struct {
+	Sender             common.Address
+	Nonce              *big.Int
+	InitCode           []byte
+	CallData           []byte
+	AccountGasLimits   [32]byte
+	PreVerificationGas *big.Int
+	GasFees            [32]byte
+	PaymasterAndData   []byte
+	Signature          []byte
+}
+
+type userOpsPerAggregatorV06 struct {
+	UserOps    []userOperationV06
+	Aggregator common.Address
+	Signature  []byte
+}
+
+type packedUserOpsPerAggregator struct {
+	UserOps    []packedUserOperation
+	Aggregator common.Address
+	Signature  []byte
+}
+
+// UserOperation is a user operation in the shape bundlers return it, the
+// v0.6 fields or the unpacked v0.7 fields.
+type UserOperation struct {
+	Sender                        common.Address  `json:"sender"`
+	Nonce                         *hexutil.Big    `json:"nonce"`
+	InitCode                      *hexutil.Bytes  `json:"initCode,omitempty"`
+	Factory                       *common.Address `json:"factory,omitempty"`
+	FactoryData                   *hexutil.Bytes  `json:"factoryData,omitempty"`
+	CallData                      hexutil.Bytes   `json:"callData"`
+	CallGasLimit                  *hexutil.Big    `json:"callGasLimit"`
+	VerificationGasLimit          *hexutil.Big    `json:"verificationGasLimit"`
+	PreVerificationGas            *hexutil.Big    `json:"preVerificationGas"`
+	MaxFeePerGas                  *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas          *hexutil.Big    `json:"maxPriorityFeePerGas"`
+	PaymasterAndData              *hexutil.Bytes  `json:"paymasterAndData,omitempty"`
+	Paymaster                     *common.Address `json:"paymaster,omitempty"`
+	PaymasterVerificationGasLimit *hexutil.Big    `json:"paymasterVerificationGasLimit,omitempty"`
+	PaymasterPostOpGasLimit       *hexutil.Big    `json:"paymasterPostOpGasLimit,omitempty"`
+	PaymasterData                 *hexutil.Bytes  `json:"paymasterData,omitempty"`
+	Signature                     hexutil.Bytes   `json:"signature"`
+}
+
+func (op *userOperationV06) toUserOperation() *UserOperation {
+	initCode := hexutil.Bytes(op.InitCode)
+	paymasterAndData := hexutil.Bytes(op.PaymasterAndData)
+	return &UserOperation{
+		Sender:               op.Sender,
+		Nonce:                (*hexutil.Big)(op.Nonce),
+		InitCode:             &initCode,
+		CallData:             op.CallData,
+		CallGasLimit:         (*hexutil.Big)(op.CallGasLimit),
+		VerificationGasLimit: (*hexutil.Big)(op.VerificationGasLimit),
+		PreVerificationGas:   (*hexutil.Big)(op.PreVerificationGas),
+		MaxFeePerGas:         (*hexutil.Big)(op.MaxFeePerGas),
+		MaxPriorityFeePerGas: (*hexutil.Big)(op.MaxPriorityFeePerGas),
+		PaymasterAndData:     &paymasterAndData,
+		Signature:            op.Signature,
+	}
+}
+
+// unpackUint128 splits a bytes32 into its high and low 128 bits.
+func unpackUint128(packed []byte) (*hexutil.Big, *hexutil.Big) {
+	return (*hexutil.Big)(new(big.Int).SetBytes(packed[:16])), (*hexutil.Big)(new(big.Int).SetBytes(packed[16:32]))
+}
+
+// packUint128 packs two 128 bits values into a bytes32.
+func packUint128(high, low *hexutil.Big) [32]byte {
+	var packed [32]byte
+	if high != nil {
+		high.ToInt().FillBytes(packed[:16])
+	}
+	if low != nil {
+		low.ToInt().FillBytes(packed[16:])
+	}
+	return packed
+}
+
+func (op *packedUserOperation) toUserOperation() *UserOperation {
+	result := &UserOperation{
+		Sender:             op.Sender,
+		Nonce:              (*hexutil.Big)(op.Nonce),
+		CallData:           op.CallData,
+		PreVerificationGas: (*hexutil.Big)(op.PreVerificationGas),
+		Signature:          op.Signature,
+	}
+	result.VerificationGasLimit, result.CallGasLimit = unpackUint128(op.AccountGasLimits[:])
+	result.MaxPriorityFeePerGas, result.MaxFeePerGas = unpackUint128(op.GasFees[:])
+
+	if len(op.InitCode) >= common.AddressLength {
+		factory := common.BytesToAddress(op.InitCode[:common.AddressLength])
+		factoryData := hexutil.Bytes(op.InitCode[common.AddressLength:])
+		result.Factory, result.FactoryData = &factory, &factoryData
+	}
+	if len(op.PaymasterAndData) >= common.AddressLength+32 {
+		paymaster := common.BytesToAddress(op.PaymasterAndData[:common.AddressLength])
+		paymasterData := hexutil.Bytes(op.PaymasterAndData[common.AddressLength+32:])
+		result.Paymaster, result.PaymasterData = &paymaster, &paymasterData
+		result.PaymasterVerificationGasLimit, result.PaymasterPostOpGasLimit = unpackUint128(op.PaymasterAndData[common.AddressLength : common.AddressLength+32])
+	}
+	return result
+}
+
+// pack returns the packed layout of an unpacked v0.7 user operation.
+func (op *UserOperation) pack() *packedUserOperation {
+	packed := &packedUserOperation{
+		Sender:             op.Sender,
+		Nonce:              op.Nonce.ToInt(),
+		CallData:           op.CallData,
+		AccountGasLimits:   packUint128(op.VerificationGasLimit, op.CallGasLimit),
+		PreVerificationGas: op.PreVerificationGas.ToInt(),
+		GasFees:            packUint128(op.MaxPriorityFeePerGas, op.MaxFeePerGas),
+		Signature:          op.Signature,
+	}
+	if op.Factory != nil {
+		packed.InitCode = append(op.Factory.Bytes(), bytesOf(op.FactoryData)...)
+	}
+	if op.Paymaster != nil {
+		gasLimits := packUint128(op.PaymasterVerificationGasLimit, op.PaymasterPostOpGasLimit)
+		packed.PaymasterAndData = append(append(op.Paymaster.Bytes(), gasLimits[:]...), bytesOf(op.PaymasterData)...)
+	}
+	return packed
+}
+
+func bytesOf(data *hexutil.Bytes) []byte {
+	if data == nil {
+		return nil
+	}
+	return *data
+}
+
+// IsBundle tells whether a calldata selector is one of the bundle methods.
+func (ep *EntryPoint) IsBundle(selector []byte) bool {
+	method, err := ep.Abi.MethodById(selector)
+	return err == nil && (method.Name == "handleOps" || method.Name == "handleAggregatedOps")
+}
+
+// DecodeBundle decodes the user operations and the beneficiary of a
+// handleOps or handleAggregatedOps calldata.
+func (ep *EntryPoint) DecodeBundle(input []byte) ([]*UserOperation, common.Address, error) {
+	if len(input) < 4 {
+		return nil, common.Address{}, errors.New("calldata too short")
+	}
+	if !ep.IsBundle(input[:4]) {
+		return nil, common.Address{}, fmt.Errorf("unknown %s bundle method %s", ep.Version, hexutil.Encode(input[:4]))
+	}
+	method, _ := ep.Abi.MethodById(input[:4])
+
+	values, err := method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("error decode %s: %w", method.Name, err)
+	}
+	beneficiary, _ := values[1].(common.Address)
+
+	var ops []*UserOperation
+	switch {
+	case method.Name == "handleOps" && ep.Packed:
+		for _, op := range *abi.ConvertType(values[0], new([]packedUserOperation)).(*[]packedUserOperation) {
+			ops = append(ops, op.toUserOperation())
+		}
+	case method.Name == "handleOps":
+		for _, op := range *abi.ConvertType(values[0], new([]userOperationV06)).(*[]userOperationV06) {
+			ops = append(ops, op.toUserOperation())
+		}
+	case ep.Packed:
+		for _, group := range *abi.ConvertType(values[0], new([]packedUserOpsPerAggregator)).(*[]packedUserOpsPerAggregator) {
+			for _, op := range group.UserOps {
+				ops = append(ops, op.toUserOperation())
+			}
+		}
+	default:
+		for _, group := range *abi.ConvertType(values[0], new([]userOpsPerAggregatorV06)).(*[]userOpsPerAggregatorV06) {
+			for _, op := range group.UserOps {
+				ops = append(ops, op.toUserOperation())
+			}
+		}
+	}
+	return ops, beneficiary, nil
+}
@@ -3,7 +3,6 @@ package indexer
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"math"
 	"math/big"
@@ -11,42 +10,94 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/chains"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/databend"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/pebble"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/redisdb"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/golang/snappy"
-	"github.com/spf13/cast"
 )
 
 var (
 	LogDescriptor = "0x49628fd1471006c1482da88028e9ce4dbb080b815c9b0344d39e5a8e6ec1419f"
-	_logTopics    = [][]common.Hash{{common.HexToHash(LogDescriptor)}}
+	// _logTopics filters all indexed entry point events, see events.go
+	_logTopics [][]common.Hash
 
 	_httpTimeout      = time.Second * 10
+	_retryInterval    = time.Second
 	nexBlockNumberMap = sync.Map{}
 	gBlockNumberMap   = sync.Map{}
+	gEntryPointsMap   = sync.Map{}
 	gLatestBlockMap   = sync.Map{}
+	gHeadsMap         = sync.Map{}
+	gBackfillMap      = sync.Map{}
+	gBlockRangeMap    = sync.Map{}
+	gPoolMap          = sync.Map{}
+
+	gQuorumMismatchMap = sync.Map{}
 )
 
 type Backend struct {
 	chain           string
 	db              database.KVStore
 	entryPoints     []common.Address
+	versions        map[common.Address]*entrypoint.EntryPoint
+	chainId         *big.Int
 	rpcUrls         []string
 	startBlock      int64
-	startBlockDbKey string
+	cursors         []*entryPointCursor
+	tailing         []common.Address
+	lagging         map[common.Address]int64
 	blockRange      int64
+	rangeSize       *adaptiveRange
 	pullingInterval time.Duration
 
+	finality      string
+	confirmations int64
+
+	reorgDepth   int64
+	window       *blockWindow
+	windowLock   sync.Mutex
+	windowPrefix string
+
+	// writeLock serializes building and writing batches, which read back
+	// the paymaster totals and bundles they update
+	writeLock sync.Mutex
+
+	wake chan struct{}
+	// joined tells the subscription a lagging entry point joined the tailing ones
+	joined   chan struct{}
+	routines sync.WaitGroup
+	fail     context.CancelCauseFunc
+
+	backfillWorkers int
+	cursorLock      sync.Mutex
+	backfilling     bool
+	tipBlock        int64
+
+	// ranged is set while CallAndSave indexes bounded ranges outside the
+	// cursors and the reorg window, rangeDbKey is where it stores its progress
+	ranged     bool
+	rangeDbKey string
+	// deferStats leaves the paymaster totals to the indexer following the
+	// head, which may run in another process, see paymasterChange
+	deferStats bool
+
 	web3Clients []*web3.Web3
+	pool        *clientPool
+
+	quorum           int
+	quorumMismatches atomic.Int64
 
 	logger log.Logger
 
@@ -62,7 +113,10 @@ func NewDb(engin, dataSource string) database.KVStore {
 	case "memory":
 		db = memorydb.New()
 	case "redis":
-		db = redisdb.NewDatabase(dataSource)
+		db, err = redisdb.NewDatabase(dataSource)
+		if err != nil {
+			panic(fmt.Sprintf("error create redis db, %v", err))
+		}
 	case "pebble":
 		db, err = pebble.NewPebbleDb(dataSource, 16, 16, false)
 		if err != nil {
@@ -81,15 +135,28 @@ func NewDb(engin, dataSource string) database.KVStore {
 }
 
 func parseUrl(str string) (*url.URL, error) {
-	if !strings.HasPrefix(str, "http://") && !strings.HasPrefix(str, "https://") {
+	if !strings.HasPrefix(str, "http://") && !strings.HasPrefix(str, "https://") && !isWebsocket(str) {
 		str = "http://" + str
 	}
 
 	return url.Parse(str)
 }
 
-func NewBackend(headers []HeadersCfg, eps []string, chain ChainCfg, db database.KVStore, compress bool) *Backend {
+func NewBackend(headers []HeadersCfg, eps []EntryPointCfg, chain ChainCfg, db database.KVStore, compress bool) *Backend {
 	logger := log.Module("backend")
+	entry, known := chains.Lookup(chain.Chain)
+	if known {
+		if chain.ChainId == "" {
+			chain.ChainId = entry.Id()
+		}
+		if chain.ChainId != entry.Id() {
+			panic(fmt.Sprintf("chain %s: chain id %s, the chain registry has %s", chain.Chain, chain.ChainId, entry.Id()))
+		}
+	}
+	if chain.ChainId == "" {
+		panic(fmt.Sprintf("chain %s: unknown chain id, set chainId or add the chain to the chain registry", chain.Chain))
+	}
+
 	var clients []*web3.Web3
 	for _, uri := range chain.Backends {
 		url, err := parseUrl(uri)
@@ -132,88 +199,219 @@ func NewBackend(headers []HeadersCfg, eps []string, chain ChainCfg, db database.
 		panic("backend no available rpc")
 	}
 
+	finality, confirmations, err := ParseFinality(chain.Finality)
+	if err != nil {
+		panic(fmt.Sprintf("chain %s: %v", chain.Chain, err))
+	}
+
+	balance, err := ParseBalance(chain.Balance)
+	if err != nil {
+		panic(fmt.Sprintf("chain %s: %v", chain.Chain, err))
+	}
+	if err := chain.validQuorum(); err != nil {
+		panic(err)
+	}
+	pool := newClientPool(clients, balance)
+	gPoolMap.Store(chain.Chain, pool)
+
 	backend := &Backend{
 		chain:           chain.Chain,
 		db:              db,
 		entryPoints:     nil,
+		versions:        map[common.Address]*entrypoint.EntryPoint{},
 		rpcUrls:         chain.Backends,
 		startBlock:      chain.StartBlock,
 		blockRange:      chain.BlockRangeSize,
+		rangeSize:       newAdaptiveRange(chain.BlockRangeSize),
 		logger:          logger,
 		compress:        compress,
 		pullingInterval: time.Millisecond * time.Duration(chain.PullingInterval),
 		web3Clients:     clients,
-		startBlockDbKey: DbKeyStartBlock(chain.Chain),
+		pool:            pool,
+		quorum:          chain.Quorum,
+		finality:        finality,
+		confirmations:   confirmations,
+		reorgDepth:      chain.ReorgDepth,
+		windowPrefix:    DbKeyReorgWindowPrefix(chain.Chain),
+		backfillWorkers: chain.BackfillWorkers,
+		wake:            make(chan struct{}, 1),
+		joined:          make(chan struct{}, 1),
 	}
 
+	if backend.reorgDepth <= 0 {
+		backend.reorgDepth = DefaultReorgDepth
+	}
+
+	if len(chain.EntryPoints) > 0 {
+		eps = chain.EntryPoints
+	}
 	for _, ep := range eps {
-		backend.entryPoints = append(backend.entryPoints, common.HexToAddress(ep))
+		address := common.HexToAddress(ep.Address)
+		backend.entryPoints = append(backend.entryPoints, address)
+		backend.versions[address] = ep.EntryPoint()
+
+		cursor := &entryPointCursor{
+			address:    address,
+			version:    ep.Version,
+			dbKey:      DbKeyStartBlock(chain.Chain, ep.Address),
+			startBlock: chain.StartBlock,
+			explicit:   ep.StartBlock > 0,
+		}
+		if cursor.explicit {
+			cursor.startBlock = ep.StartBlock
+		}
+		if known {
+			if deployment, ok := entry.Deployment(address); ok {
+				if deployment.Version != "" && deployment.Version != ep.Version {
+					panic(fmt.Sprintf("chain %s: entry point %s is %s in the chain registry, configured as %s", chain.Chain, ep.Address, deployment.Version, ep.Version))
+				}
+				cursor.deployBlock = deployment.Block
+			}
+		}
+		backend.cursors = append(backend.cursors, cursor)
+	}
+
+	if chainId, ok := new(big.Int).SetString(chain.ChainId, 10); ok {
+		backend.chainId = chainId
 	}
 
 	return backend
 }
 
+// LatestBlockNumber refreshes the heads of all backends and returns the highest
+// one, together with the best backend to continue with.
 func (b *Backend) LatestBlockNumber() (uint64, *web3.Web3, error) {
-	var cli *web3.Web3
-	var blockNumberMax uint64
-	var blockNumber uint64
-	var err error
-	for idx, _ := range b.web3Clients {
-		blockNumber, err = b.web3Clients[idx].Cli().BlockNumber(context.Background())
-		if err != nil {
-			b.logger.Warn("error get latest block number", "err", err)
-			continue
-		}
-		if blockNumber > blockNumberMax {
-			blockNumberMax = blockNumber
-			cli = b.web3Clients[idx]
-		}
+	blockNumber, err := b.pool.Heads()
+	if err != nil {
+		b.logger.Warn("error get latest block number", "err", err, "chain", b.chain)
+		return 0, nil, err
 	}
 
-	if cli == nil {
-		if err != nil {
-			err = errors.New("server error")
-		}
-	} else {
-		err = nil
+	cli, err := b.pool.Pick(0)
+	if err != nil {
+		return 0, nil, err
 	}
-
-	return blockNumberMax, cli, err
+	return blockNumber, cli, nil
 }
 
 func (b *Backend) StartBlock() int64 {
-	v, ok := nexBlockNumberMap.Load(b.startBlockDbKey)
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+	return b.nextBlock()
+}
+
+// nextBlock is where the head-following loop continues from, the caller holds
+// cursorLock.
+func (b *Backend) nextBlock() int64 {
+	if b.backfilling {
+		return b.tipBlock
+	}
+
+	v, ok := nexBlockNumberMap.Load(b.chain)
 	if ok {
 		blockNumber := v.(int64)
 		return blockNumber
 	}
+	return b.startBlock
+}
 
-	val, err := b.db.Get(b.startBlockDbKey, false)
-	if err != nil {
-		panic(fmt.Sprintf("error get db key %s: %s", b.startBlockDbKey, err.Error()))
+// SetNextStartBlock writes batch together with the cursor, which only moves if
+// the write succeeds.
+func (b *Backend) SetNextStartBlock(batch database.Batch, block int64) error {
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+
+	if !b.backfilling {
+		b.putStartBlock(batch, block)
+	}
+	if err := batch.Write(); err != nil {
+		return err
 	}
-	if len(val) == 0 {
-		return b.startBlock
+	b.moveStartBlock(block)
+	return nil
+}
+
+// moveStartBlock moves the head-following cursor once it is written. While a
+// backfill is running that cursor only lives in memory, the stored one is
+// moved by the backfill. The caller holds cursorLock.
+func (b *Backend) moveStartBlock(block int64) {
+	gBlockNumberMap.Store(b.chain, block)
+	if b.backfilling {
+		b.tipBlock = block
+		return
 	}
+	b.storeStartBlock(block)
+}
 
-	blockNumber := int64(math.Max(float64(cast.ToInt64(string(val))-b.blockRange), 0))
+// putStartBlock adds the cursor of the tailing entry points to batch, the
+// caller holds cursorLock.
+func (b *Backend) putStartBlock(batch database.Batch, block int64) {
+	next := []byte(fmt.Sprintf("%v", block))
+	for _, address := range b.tailing {
+		batch.Put(b.cursor(address).dbKey, next, false)
+	}
+}
 
-	return blockNumber
+// storeStartBlock publishes the stored cursor, the caller holds cursorLock.
+func (b *Backend) storeStartBlock(block int64) {
+	nexBlockNumberMap.Store(b.chain, block)
+	b.storeEntryPointStatus()
 }
 
-func (b *Backend) SetNextStartBlock(block int64) {
-	gBlockNumberMap.Store(b.chain, block)
-	next := []byte(fmt.Sprintf("%v", block))
-	err := b.db.Put(b.startBlockDbKey, next, false)
-	if err != nil {
-		panic(fmt.Sprintf("error put db key %s: %s", b.startBlockDbKey, err.Error()))
+// sleep waits for d, it returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
 	}
-	nexBlockNumberMap.Store(b.startBlockDbKey, block)
 }
 
-func (b *Backend) Run() error {
-	for {
+// goRoutine runs fn in a goroutine Run waits for before returning. A panic in
+// fn stops the backend with an error.
+func (b *Backend) goRoutine(fn func()) {
+	b.routines.Add(1)
+	go func() {
+		defer b.routines.Done()
+		var err error
+		defer func() {
+			if err != nil {
+				b.fail(err)
+			}
+		}()
+		defer recoverError(&err)
+		fn()
+	}()
+}
+
+// Run indexes the chain until ctx is done or the backend fails. It returns
+// once the current round and the background catch up, backfill and
+// subscriptions have stopped, so nothing writes to the store anymore.
+func (b *Backend) Run(parent context.Context) error {
+	ctx, fail := context.WithCancelCause(parent)
+	b.fail = fail
+	defer b.routines.Wait()
+	defer fail(nil)
+
+	if err := b.loadCursors(ctx); err != nil {
+		return err
+	}
+	for _, c := range b.cursors {
+		if _, ok := b.laggingCursor(c.address); ok {
+			address := c.address
+			b.goRoutine(func() {
+				b.catchUp(ctx, address)
+			})
+		}
+	}
+	b.goRoutine(func() {
+		b.subscribe(ctx)
+	})
+
+	for ctx.Err() == nil {
 		startTime := time.Now()
+		behind := false
 
 		err := func() error {
 			latestBlockNumber, cli, err := b.LatestBlockNumber()
@@ -223,12 +421,25 @@ func (b *Backend) Run() error {
 
 			gLatestBlockMap.Store(b.chain, int64(latestBlockNumber))
 
+			headBlockNumber, err := b.updateHeads(int64(latestBlockNumber), cli)
+			if err != nil {
+				return err
+			}
+
 			fromBlock := b.StartBlock()
-			if fromBlock == int64(latestBlockNumber) {
+			if b.needBackfill(fromBlock, headBlockNumber) {
+				b.startBackfill(ctx, fromBlock, headBlockNumber)
+				fromBlock = b.StartBlock()
+			}
+			if fromBlock >= headBlockNumber {
 				return nil
 			}
 
-			toBlock := int64(math.Min(float64(fromBlock+b.blockRange-1), float64(latestBlockNumber)))
+			toBlock := int64(math.Min(float64(fromBlock+b.rangeSize.Size()-1), float64(headBlockNumber)))
+			cli, err = b.pool.Pick(toBlock)
+			if err != nil {
+				return err
+			}
 			if fromBlock > toBlock {
 				//b.logger.Debug(fmt.Sprintf("error block range from > to: %v > %v", fromBlock, toBlock))
 				//return fmt.Errorf("error block range from > to: %v > %v", fromBlock, toBlock)
@@ -239,15 +450,31 @@ func (b *Backend) Run() error {
 			//	fromBlock = toBlock - b.blockRange + 1
 			//}
 
+			behind = toBlock < headBlockNumber
 			return b.CallAndSave(fromBlock, toBlock, cli)
 		}()
 
 		if err != nil {
 			b.logger.Error(err.Error())
+		} else {
+			setHealthy(b.chain)
+		}
+		if err == nil && behind {
+			// the polling interval paces following the head, not catching up
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(b.pullingInterval - time.Since(startTime)):
+		case <-b.wake:
 		}
-		time.Sleep(b.pullingInterval - time.Since(startTime))
 	}
-	//return errors.New("backend exited")
+	if parent.Err() == nil {
+		return context.Cause(ctx)
+	}
+	b.logger.Info("backend stopped", "chain", b.chain)
+	return nil
 }
 
 func (b *Backend) CallAndSave(fromBlock, toBlock int64, cli *web3.Web3) error {
@@ -256,35 +483,249 @@ func (b *Backend) CallAndSave(fromBlock, toBlock int64, cli *web3.Web3) error {
 	ctx, cancelFunc := context.WithTimeout(context.Background(), _httpTimeout)
 	defer cancelFunc()
 
-	param := ethereum.FilterQuery{
-		FromBlock: big.NewInt(fromBlock),
-		ToBlock:   big.NewInt(toBlock),
-		Addresses: b.entryPoints,
-		Topics:    _logTopics,
+	// a bounded range is below the reorg depth, it is neither checked against
+	// nor recorded in the reorg window
+	window := &blockWindow{}
+	if !b.ranged {
+		b.windowLock.Lock()
+		defer b.windowLock.Unlock()
+
+		reorged, err := b.checkReorg(ctx, cli)
+		if err != nil {
+			b.logger.Error("error check reorg", "err", err, "url", cli.Url(), "chain", b.chain)
+			return err
+		}
+		if reorged {
+			return nil
+		}
+		window = b.window
 	}
-	ethlogs, err := cli.Cli().FilterLogs(ctx, param)
+
+	addresses := b.tailingEntryPoints()
+	ethlogs, err := b.fetchLogs(fromBlock, toBlock, addresses, cli)
 	if err != nil {
 		b.logger.Error("error filter logs", "err", err, "url", cli.Url(), "chain", b.chain)
 		return err
 	}
 
-	nextBlockNumber := toBlock
+	fetched, err := b.enrichRange(fromBlock, toBlock, ethlogs, cli)
+	if err != nil {
+		b.logger.Error("error fetch logs data", "err", err, "url", cli.Url(), "chain", b.chain)
+		return err
+	}
+
+	header, err := cli.Cli().HeaderByNumber(ctx, big.NewInt(toBlock))
+	if err != nil {
+		b.logger.Error("error get header", "err", err, "block", toBlock, "url", cli.Url(), "chain", b.chain)
+		return err
+	}
+
+	// the logs and the header must all be on the chain we have indexed so far,
+	// otherwise the chain moved under us and the range is fetched again
 	for _, ethlog := range ethlogs {
-		hash := ethlog.Topics[1].Hex()
-		data, _ := json.Marshal(ethlog)
+		blockNumber := int64(ethlog.BlockNumber)
+		if blockNumber == toBlock && ethlog.BlockHash != header.Hash() {
+			return fmt.Errorf("block %v hash mismatch, log %s header %s", blockNumber, ethlog.BlockHash.Hex(), header.Hash().Hex())
+		}
+		if block := window.get(blockNumber); block != nil && block.Hash != ethlog.BlockHash {
+			return fmt.Errorf("block %v hash mismatch, log %s indexed %s", blockNumber, ethlog.BlockHash.Hex(), block.Hash.Hex())
+		}
+	}
+
+	nextBlockNumber := toBlock
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
 
-		if b.compress {
-			data = snappy.Encode(nil, data)
+	batch := b.db.NewBatch()
+	if err := b.saveLogs(batch, fetched, window); err != nil {
+		b.window = nil
+		return err
+	}
+	if err := b.recordRange(batch, addresses, fromBlock, toBlock); err != nil {
+		b.window = nil
+		return err
+	}
+
+	if b.ranged {
+		if len(b.rangeDbKey) > 0 {
+			batch.Put(b.rangeDbKey, []byte(fmt.Sprintf("%v", nextBlockNumber)), false)
+		}
+		if err := batch.Write(); err != nil {
+			return fmt.Errorf("error write logs range [%v,%v]: %w", fromBlock, toBlock, err)
+		}
+		if len(ethlogs) > 0 {
+			b.logger.Info("import logs", "size", len(ethlogs), "chain", b.chain)
 		}
+		return nil
+	}
 
-		b.db.Put(DbKeyUserOp(b.chain, hash), data, b.compress)
-		//nextBlockNumber = int64(ethlog.BlockNumber + 1)
+	if err := b.applyPaymasterChanges(batch); err != nil {
+		b.window = nil
+		return err
+	}
+
+	window.add(toBlock, header.Hash(), "")
+	window.prune(toBlock - b.reorgDepth)
+	if err := b.saveWindow(batch); err != nil {
+		b.window = nil
+		return err
+	}
+
+	if err := b.SetNextStartBlock(batch, nextBlockNumber); err != nil {
+		// the window is reloaded from the store, the cursor has not moved
+		b.window = nil
+		return fmt.Errorf("error write logs range [%v,%v]: %w", fromBlock, toBlock, err)
 	}
 
 	if len(ethlogs) > 0 {
 		b.logger.Info("import logs", "size", len(ethlogs), "chain", b.chain)
 	}
-
-	b.SetNextStartBlock(nextBlockNumber)
 	return nil
 }
+
+// fetchLogs fetches the logs of a block range from cli, or from several
+// backends if the chain requires a quorum.
+func (b *Backend) fetchLogs(fromBlock, toBlock int64, addresses []common.Address, cli *web3.Web3) ([]types.Log, error) {
+	if b.quorum > 1 {
+		return b.quorumLogs(fromBlock, toBlock, addresses)
+	}
+	return b.filterLogs(fromBlock, toBlock, addresses, cli)
+}
+
+// filterLogs fetches the logs of the entry points in a block range. Ranges rejected for provider
+// limits are split and fetched in parts, shrinking the effective block range.
+func (b *Backend) filterLogs(fromBlock, toBlock int64, addresses []common.Address, cli *web3.Web3) ([]types.Log, error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), _httpTimeout)
+	defer cancelFunc()
+
+	param := ethereum.FilterQuery{
+		FromBlock: big.NewInt(fromBlock),
+		ToBlock:   big.NewInt(toBlock),
+		Addresses: addresses,
+		Topics:    _logTopics,
+	}
+	startTime := time.Now()
+	ethlogs, err := cli.Cli().FilterLogs(ctx, param)
+	if isRangeLimitError(err) {
+		b.pool.Report(cli, time.Since(startTime), nil)
+	} else {
+		b.pool.Report(cli, time.Since(startTime), err)
+	}
+	if err == nil {
+		b.setRangeSize(b.rangeSize.Success(toBlock - fromBlock + 1))
+		return ethlogs, nil
+	}
+	if fromBlock >= toBlock || !isRangeLimitError(err) {
+		return nil, err
+	}
+
+	midBlock, ok := suggestedToBlock(err, fromBlock, toBlock)
+	if !ok {
+		midBlock = fromBlock + (toBlock-fromBlock)/2
+	}
+	b.setRangeSize(b.rangeSize.Shrink(toBlock - fromBlock + 1))
+	b.logger.Warn(fmt.Sprintf("split logs range [%v,%v] at %v", fromBlock, toBlock, midBlock), "err", err, "url", cli.Url(), "chain", b.chain)
+
+	left, err := b.filterLogs(fromBlock, midBlock, addresses, cli)
+	if err != nil {
+		return nil, err
+	}
+	right, err := b.filterLogs(midBlock+1, toBlock, addresses, cli)
+	if err != nil {
+		return nil, err
+	}
+	return append(left, right...), nil
+}
+
+func (b *Backend) setRangeSize(size int64) {
+	gBlockRangeMap.Store(b.chain, size)
+}
+
+func (b *Backend) put(batch database.Batch, key string, data []byte) error {
+	if b.compress {
+		data = snappy.Encode(nil, data)
+	}
+	return batch.Put(key, data, b.compress)
+}
+
+// saveLogs adds the logs and their decoded records to batch, recording the
+// written keys in the reorg window if one is given.
+func (b *Backend) saveLogs(batch database.Batch, fetched *rangeLogs, window *blockWindow) error {
+	for _, ethlog := range fetched.logs {
+		key, ok := eventKey(b.chain, &ethlog)
+		if !ok {
+			continue
+		}
+		keys := []string{key}
+		var records [][]byte
+
+		if isUserOperationEvent(&ethlog) {
+			opHash := ethlog.Topics[1].Hex()
+			recordKey := DbKeyEvent(b.chain, SpaceUserOpEvent, opHash)
+			keys = append(keys, recordKey)
+			keys = append(keys, DbKeyEventLog(b.chain, SpaceSender, topicAddress(ethlog.Topics[2]), ethlog.BlockNumber, ethlog.Index))
+			sponsored := ethlog.Topics[3] != (common.Hash{})
+			if sponsored {
+				keys = append(keys, DbKeyEventLog(b.chain, SpacePaymaster, topicAddress(ethlog.Topics[3]), ethlog.BlockNumber, ethlog.Index))
+			}
+			if !ethlog.Removed {
+				event, err := DecodeUserOperationEvent(&ethlog, fetched.timestamps[ethlog.BlockHash])
+				if err != nil {
+					return fmt.Errorf("error decode log %s:%v: %w", ethlog.TxHash.Hex(), ethlog.Index, err)
+				}
+				if err := b.accountUserOpEvent(batch, opHash, event); err != nil {
+					return err
+				}
+				record, _ := json.Marshal(event)
+				records = append(records, record, []byte(opHash))
+				if sponsored {
+					records = append(records, []byte(opHash))
+				}
+				if record := b.userOperationRecord(fetched, event); record != nil {
+					keys = append(keys, DbKeyEvent(b.chain, SpaceUserOperation, opHash))
+					records = append(records, record)
+				}
+				receipt, err := b.userOperationReceipt(fetched, event)
+				if err != nil {
+					return fmt.Errorf("error split receipt %s: %w", ethlog.TxHash.Hex(), err)
+				}
+				if receipt != nil {
+					keys = append(keys, DbKeyEvent(b.chain, SpaceUserOpReceipt, opHash), DbKeyEvent(b.chain, SpaceReceipt, ethlog.TxHash.Hex()))
+					records = append(records, receipt, fetched.receipts[ethlog.TxHash])
+				}
+			} else {
+				keys = append(keys, DbKeyEvent(b.chain, SpaceUserOperation, opHash), DbKeyEvent(b.chain, SpaceUserOpReceipt, opHash))
+				shared, err := b.sharedReceipt(batch, fetched, &ethlog)
+				if err != nil {
+					return err
+				}
+				if !shared {
+					keys = append(keys, DbKeyEvent(b.chain, SpaceReceipt, ethlog.TxHash.Hex()))
+				}
+			}
+		}
+
+		if ethlog.Removed {
+			for _, key := range keys {
+				if err := b.deleteKey(batch, key); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		data, _ := json.Marshal(ethlog)
+		records = append([][]byte{data}, records...)
+
+		for i, key := range keys {
+			if err := b.put(batch, key, records[i]); err != nil {
+				return err
+			}
+			if window != nil {
+				window.add(int64(ethlog.BlockNumber), ethlog.BlockHash, key)
+			}
+		}
+		//nextBlockNumber = int64(ethlog.BlockNumber + 1)
+	}
+	return b.saveBundles(batch, fetched, window)
+}
@@ -0,0 +1,272 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/sync/errgroup"
+)
+
+// BackfillStatus is the progress of a running historical backfill.
+type BackfillStatus struct {
+	BlockNumber int64 `json:"block_number"`
+	TargetBlock int64 `json:"target_block"`
+}
+
+type backfillWindow struct {
+	fromBlock int64
+	toBlock   int64
+	addresses []common.Address
+	logs      chan *rangeLogs
+}
+
+func (b *Backend) isBackfilling() bool {
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+	return b.backfilling
+}
+
+// needBackfill reports whether the gap between the cursor and the head is too
+// large to be closed by the head-following loop in reasonable time.
+func (b *Backend) needBackfill(fromBlock, headBlockNumber int64) bool {
+	if b.backfillWorkers <= 0 || b.isBackfilling() {
+		return false
+	}
+	return headBlockNumber-b.reorgDepth-fromBlock > b.blockRange*int64(b.backfillWorkers)
+}
+
+// startBackfill indexes [fromBlock, headBlockNumber-reorgDepth] in the
+// background, while the head-following loop continues from the end of that
+// range. The stored cursor only moves with the backfill, so a restart resumes
+// from the first window that has not been committed.
+func (b *Backend) startBackfill(ctx context.Context, fromBlock, headBlockNumber int64) {
+	toBlock := headBlockNumber - b.reorgDepth
+
+	b.cursorLock.Lock()
+	b.backfilling = true
+	b.tipBlock = toBlock
+	b.cursorLock.Unlock()
+
+	gBackfillMap.Store(b.chain, BackfillStatus{BlockNumber: fromBlock, TargetBlock: toBlock})
+	b.logger.Info(fmt.Sprintf("start backfill range [%v,%v]", fromBlock, toBlock), "workers", b.backfillWorkers, "chain", b.chain)
+
+	b.goRoutine(func() {
+		startTime := time.Now()
+		if !b.backfill(ctx, fromBlock, toBlock) {
+			b.logger.Info("backfill interrupted", "chain", b.chain)
+			return
+		}
+
+		b.cursorLock.Lock()
+		b.backfilling = false
+		// the blocks above the backfill were written by the head-following
+		// loop, only its cursor is left to store
+		tipBlock := int64(math.Max(float64(toBlock), float64(b.tipBlock)))
+		batch := b.db.NewBatch()
+		b.putStartBlock(batch, tipBlock)
+		if err := batch.Write(); err != nil {
+			b.logger.Error("error write cursor", "err", err, "block", tipBlock, "chain", b.chain)
+		} else {
+			b.moveStartBlock(tipBlock)
+		}
+		b.cursorLock.Unlock()
+
+		gBackfillMap.Delete(b.chain)
+		b.logger.Info(fmt.Sprintf("backfill range [%v,%v] done", fromBlock, toBlock), "elapsed", time.Since(startTime), "chain", b.chain)
+	})
+}
+
+// backfill fetches the windows of [fromBlock, toBlock] concurrently and commits
+// them in order. It returns false if ctx is done before the range is.
+func (b *Backend) backfill(ctx context.Context, fromBlock, toBlock int64) bool {
+	queue := make(chan *backfillWindow, b.backfillWorkers*2)
+
+	// the producer stops with ctx, which is done whenever backfill returns early
+	b.goRoutine(func() {
+		defer close(queue)
+
+		wg := errgroup.Group{}
+		wg.SetLimit(b.backfillWorkers)
+		for start := fromBlock; start <= toBlock; {
+			window := &backfillWindow{
+				fromBlock: start,
+				toBlock:   int64(math.Min(float64(start+b.rangeSize.Size()-1), float64(toBlock))),
+				addresses: b.tailingEntryPoints(),
+				logs:      make(chan *rangeLogs, 1),
+			}
+			start = window.toBlock + 1
+			select {
+			case queue <- window:
+			case <-ctx.Done():
+				return
+			}
+			wg.Go(func() error {
+				window.logs <- b.fetchWindow(ctx, window.fromBlock, window.toBlock, window.addresses)
+				return nil
+			})
+		}
+		wg.Wait()
+	})
+
+	for window := range queue {
+		fetched := <-window.logs
+		if fetched == nil {
+			return false
+		}
+		for {
+			err := b.saveBackfill(fetched, window)
+			if err == nil {
+				break
+			}
+			b.logger.Error("error save backfill logs", "err", err, "chain", b.chain)
+			if !sleep(ctx, _retryInterval) {
+				return false
+			}
+		}
+
+		gBackfillMap.Store(b.chain, BackfillStatus{BlockNumber: window.toBlock, TargetBlock: toBlock})
+		if len(fetched.logs) > 0 {
+			b.logger.Info(fmt.Sprintf("backfill logs range [%v,%v]", window.fromBlock, window.toBlock), "size", len(fetched.logs), "chain", b.chain)
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// saveBackfill writes the logs of a backfill window together with the stored
+// cursor.
+func (b *Backend) saveBackfill(fetched *rangeLogs, window *backfillWindow) error {
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	batch := b.db.NewBatch()
+	if err := b.saveLogs(batch, fetched, nil); err != nil {
+		return err
+	}
+	if err := b.recordRange(batch, window.addresses, window.fromBlock, window.toBlock); err != nil {
+		return err
+	}
+	toBlock := window.toBlock
+
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+	b.putStartBlock(batch, toBlock)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	b.storeStartBlock(toBlock)
+	return nil
+}
+
+// fetchWindow fetches the logs of a window, retrying on the next backend until
+// it succeeds. It returns nil if ctx is done first.
+func (b *Backend) fetchWindow(ctx context.Context, fromBlock, toBlock int64, addresses []common.Address) *rangeLogs {
+	for ctx.Err() == nil {
+		cli, err := b.pool.Next(toBlock)
+		if err != nil {
+			b.logger.Error(fmt.Sprintf("error backfill logs range [%v,%v]", fromBlock, toBlock), "err", err, "chain", b.chain)
+			sleep(ctx, _retryInterval)
+			continue
+		}
+
+		ethlogs, err := b.fetchLogs(fromBlock, toBlock, addresses, cli)
+		if err == nil {
+			var fetched *rangeLogs
+			fetched, err = b.enrichRange(fromBlock, toBlock, ethlogs, cli)
+			if err == nil {
+				return fetched
+			}
+		}
+
+		b.logger.Error(fmt.Sprintf("error backfill logs range [%v,%v]", fromBlock, toBlock), "err", err, "url", cli.Url(), "chain", b.chain)
+		sleep(ctx, _retryInterval)
+	}
+	return nil
+}
+
+// IndexRange (re)indexes [fromBlock, toBlock] window by window with
+// CallAndSave, leaving the cursors and the reorg window untouched. The progress
+// is stored with each window, so an interrupted run of the same range resumes
+// from the first window that has not been committed.
+func (b *Backend) IndexRange(ctx context.Context, fromBlock, toBlock int64) error {
+	if fromBlock < 0 || fromBlock > toBlock {
+		return fmt.Errorf("invalid block range [%v,%v]", fromBlock, toBlock)
+	}
+	headBlockNumber, err := b.pool.Heads()
+	if err != nil {
+		return err
+	}
+	if safeBlock := int64(headBlockNumber) - b.reorgDepth; toBlock > safeBlock {
+		return fmt.Errorf("block %v is within the reorg depth %v of the head %v, the last block to backfill is %v", toBlock, b.reorgDepth, headBlockNumber, safeBlock)
+	}
+
+	var addresses []string
+	for _, address := range b.entryPoints {
+		addresses = append(addresses, address.Hex())
+	}
+	b.cursorLock.Lock()
+	b.tailing = append([]common.Address(nil), b.entryPoints...)
+	b.cursorLock.Unlock()
+	b.ranged = true
+	b.deferStats = true
+	b.rangeDbKey = DbKeyBackfillRange(b.chain, fromBlock, toBlock, addresses)
+
+	start := fromBlock
+	val, err := b.db.Get(b.rangeDbKey, false)
+	if err != nil {
+		return fmt.Errorf("error get db key %s: %w", b.rangeDbKey, err)
+	}
+	if len(val) > 0 {
+		blockNumber, err := strconv.ParseInt(string(val), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid backfill progress %q: %w", val, err)
+		}
+		start = blockNumber + 1
+		b.logger.Info(fmt.Sprintf("resume backfill range [%v,%v] at %v", fromBlock, toBlock, start), "chain", b.chain)
+	} else {
+		b.logger.Info(fmt.Sprintf("start backfill range [%v,%v]", fromBlock, toBlock), "entrypoints", addresses, "chain", b.chain)
+	}
+
+	startTime := time.Now()
+	total := toBlock - start + 1
+	for start <= toBlock {
+		end := int64(math.Min(float64(start+b.rangeSize.Size()-1), float64(toBlock)))
+		if err := b.indexWindow(ctx, start, end); err != nil {
+			return err
+		}
+
+		done := end - (toBlock - total)
+		elapsed := time.Since(startTime)
+		eta := time.Duration(float64(elapsed) / float64(done) * float64(toBlock-end)).Round(time.Second)
+		b.logger.Info(fmt.Sprintf("backfill range [%v,%v] at %v", fromBlock, toBlock, end), "progress", fmt.Sprintf("%.1f%%", float64(done)*100/float64(total)), "eta", eta, "chain", b.chain)
+
+		start = end + 1
+	}
+
+	if err := b.db.Delete(b.rangeDbKey); err != nil {
+		b.logger.Warn("error delete backfill progress", "err", err, "key", b.rangeDbKey, "chain", b.chain)
+	}
+	b.logger.Info(fmt.Sprintf("backfill range [%v,%v] done", fromBlock, toBlock), "elapsed", time.Since(startTime).Round(time.Second), "chain", b.chain)
+	return nil
+}
+
+// indexWindow indexes a window of a bounded range with CallAndSave, retrying
+// until it succeeds or ctx is done.
+func (b *Backend) indexWindow(ctx context.Context, fromBlock, toBlock int64) error {
+	for {
+		cli, err := b.pool.Pick(toBlock)
+		if err == nil {
+			err = b.CallAndSave(fromBlock, toBlock, cli)
+		}
+		if err == nil {
+			return ctx.Err()
+		}
+		b.logger.Error(fmt.Sprintf("error index logs range [%v,%v]", fromBlock, toBlock), "err", err, "chain", b.chain)
+		if !sleep(ctx, _retryInterval) {
+			return ctx.Err()
+		}
+	}
+}
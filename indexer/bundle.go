@@ -0,0 +1,140 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// SpaceBundle holds the user operations of each bundle transaction.
+const SpaceBundle = "bundle"
+
+// bundleTx is the part of a bundle transaction needed to index it.
+type bundleTx struct {
+	Hash  common.Hash     `json:"hash"`
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Input hexutil.Bytes   `json:"input"`
+}
+
+// beneficiary decodes the beneficiary from the calldata of a direct call to
+// the entry point, the second argument of all bundle methods.
+func (tx *bundleTx) beneficiary(ep *entrypoint.EntryPoint) (common.Address, bool) {
+	if ep == nil || len(tx.Input) < 4+64 || !ep.IsBundle(tx.Input[:4]) {
+		return common.Address{}, false
+	}
+	return common.BytesToAddress(tx.Input[4+32 : 4+64]), true
+}
+
+// Bundle is a bundle transaction with its user operations in execution order.
+type Bundle struct {
+	TransactionHash common.Hash           `json:"transactionHash"`
+	BlockNumber     hexutil.Uint64        `json:"blockNumber"`
+	BlockHash       common.Hash           `json:"blockHash"`
+	EntryPoint      common.Address        `json:"entryPoint"`
+	Bundler         common.Address        `json:"bundler"`
+	Beneficiary     *common.Address       `json:"beneficiary"`
+	UserOperations  []*UserOperationEvent `json:"userOperations"`
+}
+
+// merge adds the user operation of a log to the bundle, or removes it if the
+// log was removed. Logs of another block replace the bundle.
+func (bundle *Bundle) merge(ethlog *types.Log, event *UserOperationEvent) {
+	if bundle.BlockHash != ethlog.BlockHash {
+		if ethlog.Removed {
+			return
+		}
+		bundle.BlockNumber = hexutil.Uint64(ethlog.BlockNumber)
+		bundle.BlockHash = ethlog.BlockHash
+		bundle.UserOperations = nil
+	}
+
+	ops := bundle.UserOperations[:0]
+	for _, op := range bundle.UserOperations {
+		if uint(op.LogIndex) != ethlog.Index {
+			ops = append(ops, op)
+		}
+	}
+	if !ethlog.Removed {
+		ops = append(ops, event)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].LogIndex < ops[j].LogIndex
+	})
+	bundle.UserOperations = ops
+}
+
+// saveBundles groups the UserOperationEvent logs by transaction and merges
+// them into the stored bundles.
+func (b *Backend) saveBundles(batch database.Batch, fetched *rangeLogs, window *blockWindow) error {
+	bundles := map[common.Hash]*Bundle{}
+	var order []common.Hash
+
+	for _, ethlog := range fetched.logs {
+		if !isUserOperationEvent(&ethlog) {
+			continue
+		}
+
+		key := DbKeyEvent(b.chain, SpaceBundle, ethlog.TxHash.Hex())
+		bundle, ok := bundles[ethlog.TxHash]
+		if !ok {
+			bundle = &Bundle{}
+			data, err := b.get(batch, key)
+			if err != nil {
+				return err
+			}
+			if len(data) > 0 {
+				if err := json.Unmarshal(data, bundle); err != nil {
+					return fmt.Errorf("error decode db key %s: %w", key, err)
+				}
+			}
+			bundles[ethlog.TxHash] = bundle
+			order = append(order, ethlog.TxHash)
+		}
+
+		var event *UserOperationEvent
+		if !ethlog.Removed {
+			var err error
+			event, err = DecodeUserOperationEvent(&ethlog, fetched.timestamps[ethlog.BlockHash])
+			if err != nil {
+				return fmt.Errorf("error decode log %s:%v: %w", ethlog.TxHash.Hex(), ethlog.Index, err)
+			}
+			bundle.TransactionHash = ethlog.TxHash
+			bundle.EntryPoint = ethlog.Address
+			if tx := fetched.transactions[ethlog.TxHash]; tx != nil {
+				bundle.Bundler = tx.From
+				bundle.Beneficiary = nil
+				if beneficiary, ok := tx.beneficiary(b.bundleEntryPoint(tx)); ok {
+					bundle.Beneficiary = &beneficiary
+				}
+			}
+		}
+		bundle.merge(&ethlog, event)
+	}
+
+	for _, txHash := range order {
+		bundle := bundles[txHash]
+		key := DbKeyEvent(b.chain, SpaceBundle, txHash.Hex())
+		if len(bundle.UserOperations) == 0 {
+			if err := b.deleteKey(batch, key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, _ := json.Marshal(bundle)
+		if err := b.put(batch, key, data); err != nil {
+			return err
+		}
+		if window != nil {
+			window.add(int64(bundle.BlockNumber), bundle.BlockHash, key)
+		}
+	}
+	return nil
+}
@@ -2,56 +2,31 @@ package indexer
 
 import (
 	"errors"
+	"fmt"
 	"math"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/chains"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/urfave/cli/v2"
 
 	"gopkg.in/yaml.v3"
 )
 
-var (
-	DefaultEntryPoints = []string{"0x5ff137d4b0fdcd49dca30c7cf57e578a026d2789", "0xdc5319815cdaac2d113f7f275bc893ed7d9ca469"}
-
-	DefaultChainId = map[string]string{
-		"ethereum": "1",
-		"goerli":   "5",
-		"sepolia":  "11155111",
-
-		"polygon":        "137",
-		"polygon-mumbai": "80001",
-
-		"optimism":        "10",
-		"optimism-goerli": "420",
-
-		"arbitrum":        "421613",
-		"arbitrum-one":    "42161",
-		"arbitrum-nova":   "42170",
-		"arbitrum-goerli": "421613",
-		"bsc":             "56",
-	}
-
-	DefaultStartBlocks = map[string]int64{
-		"ethereum": 17066994,
-		"goerli":   8812127,
-		"sepolia":  3296058,
+const DefaultShutdownTimeout = 30 * time.Second
 
-		"polygon":        41402415,
-		"polygon-mumbai": 34239265,
-
-		"optimism":        93335977,
-		"optimism-goerli": 10442160,
-
-		"arbitrum":        79305493,
-		"arbitrum-nova":   8945015,
-		"arbitrum-goerli": 17068300,
-		"bsc":             27251985,
+var (
+	DefaultEntryPoints = []EntryPointCfg{
+		{Address: "0x5ff137d4b0fdcd49dca30c7cf57e578a026d2789", Version: entrypoint.V06},
+		{Address: "0xdc5319815cdaac2d113f7f275bc893ed7d9ca469", Version: entrypoint.V06},
 	}
 )
 
 type Config struct {
-	EntryPoints   []string `yaml:"entryPoints"`
+	EntryPoints   []EntryPointCfg `yaml:"entryPoints"`
 	Listen        string
 	UseTls        bool   `yaml:"useTls"`
 	TlsPubKey     string `yaml:"tlsPubKey"`
@@ -62,6 +37,109 @@ type Config struct {
 	Db            DBCfg
 	Chains        []ChainCfg
 	Headers       []HeadersCfg
+	// ChainRegistry is a json file or directory overriding the built-in
+	// chain registry
+	ChainRegistry string `yaml:"chainRegistry"`
+	// ShutdownTimeout bounds draining the servers on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// EntryPointCfg is an entry point contract and its version. It is written as
+// `address`, `address@version` or a mapping, the version may be left out for
+// the canonical deployments and defaults to v0.6 otherwise.
+type EntryPointCfg struct {
+	Address string
+	Version string
+	// StartBlock is where indexing the entry point starts, the start block of
+	// the chain if not set
+	StartBlock int64 `yaml:"startBlock"`
+}
+
+func ParseEntryPointCfg(value string) (EntryPointCfg, error) {
+	address, version, _ := strings.Cut(strings.TrimSpace(value), "@")
+	ep := EntryPointCfg{Address: address, Version: version}
+	return ep, ep.resolve()
+}
+
+// ParseEntryPoints parses a comma separated list of entry points.
+func ParseEntryPoints(value string) ([]EntryPointCfg, error) {
+	var eps []EntryPointCfg
+	for _, item := range strings.Split(value, ",") {
+		ep, err := ParseEntryPointCfg(item)
+		if err != nil {
+			return nil, err
+		}
+		eps = append(eps, ep)
+	}
+	return eps, nil
+}
+
+func (e *EntryPointCfg) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		ep, err := ParseEntryPointCfg(value.Value)
+		if err != nil {
+			return err
+		}
+		*e = ep
+		return nil
+	}
+
+	type plain EntryPointCfg
+	if err := value.Decode((*plain)(e)); err != nil {
+		return err
+	}
+	return e.resolve()
+}
+
+// resolve validates the address and fills in the version.
+func (e *EntryPointCfg) resolve() error {
+	if !common.IsHexAddress(e.Address) {
+		return fmt.Errorf("invalid entry point address '%s'", e.Address)
+	}
+	e.Address = strings.ToLower(e.Address)
+
+	if e.Version == "" {
+		e.Version = entrypoint.V06
+		if ep, ok := entrypoint.Lookup(common.HexToAddress(e.Address)); ok {
+			e.Version = ep.Version
+		}
+	}
+	ep, err := entrypoint.Get(e.Version)
+	if err != nil {
+		return fmt.Errorf("entry point %s: %w", e.Address, err)
+	}
+	e.Version = ep.Version
+	return nil
+}
+
+// EntryPoint returns the registered version of the entry point.
+func (e *EntryPointCfg) EntryPoint() *entrypoint.EntryPoint {
+	ep, err := entrypoint.Get(e.Version)
+	if err != nil {
+		panic(err)
+	}
+	return ep
+}
+
+// ChainEntryPoints returns the entry points indexed on a chain, its own ones
+// or else the global ones.
+func (c *Config) ChainEntryPoints(chain string) []EntryPointCfg {
+	for _, chainCfg := range c.Chains {
+		if chainCfg.Chain == chain && len(chainCfg.EntryPoints) > 0 {
+			return chainCfg.EntryPoints
+		}
+	}
+	return c.EntryPoints
+}
+
+// EntryPointAddresses returns the lower case addresses of the entry points
+// indexed on a chain.
+func (c *Config) EntryPointAddresses(chain string) []string {
+	var addresses []string
+	for _, ep := range c.ChainEntryPoints(chain) {
+		addresses = append(addresses, ep.Address)
+	}
+	return addresses
 }
 
 type DBCfg struct {
@@ -70,12 +148,28 @@ type DBCfg struct {
 }
 
 type ChainCfg struct {
-	Chain           string
-	ChainId         string `yaml:"chainId"`
-	Backends        []string
-	StartBlock      int64 `yaml:"startBlock"`
-	BlockRangeSize  int64 `yaml:"blockRangeSize"`
-	PullingInterval int64 `yaml:"pullingInterval"`
+	Chain    string
+	ChainId  string `yaml:"chainId"`
+	Backends []string
+	// EntryPoints overrides the global entry points for the chain, each with
+	// its own start block and cursor
+	EntryPoints     []EntryPointCfg `yaml:"entryPoints"`
+	StartBlock      int64           `yaml:"startBlock"`
+	BlockRangeSize  int64           `yaml:"blockRangeSize"`
+	PullingInterval int64           `yaml:"pullingInterval"`
+	ReorgDepth      int64           `yaml:"reorgDepth"`
+	// Finality selects the indexing head: latest, safe, finalized or a
+	// number of confirmations
+	Finality string
+	// BackfillWorkers is the number of concurrent eth_getLogs requests used
+	// to catch up with the head, 0 disables the backfill
+	BackfillWorkers int `yaml:"backfillWorkers"`
+	// Balance picks the backend of each request: score picks the healthiest,
+	// weighted spreads requests in a weighted round-robin
+	Balance string
+	// Quorum is the number of backends that must return the same logs for
+	// a block range before they are indexed, 0 or 1 trusts a single backend
+	Quorum int
 }
 
 type HeadersCfg struct {
@@ -95,15 +189,10 @@ func ParseConfigFromCmd(ctx *cli.Context) (*Config, error) {
 	chainId := ""
 	if ctx.IsSet(FlagChainId.Name) {
 		chainId = ctx.String(FlagChainId.Name)
+	} else if entry, ok := chains.Lookup(chain); ok {
+		chainId = entry.Id()
 	} else {
-		chainId = DefaultChainId[chain]
-	}
-
-	var startBlock int64
-	if ctx.IsSet(FlagEthLogsStartBlock.Name) {
-		startBlock = ctx.Int64(FlagEthLogsStartBlock.Name)
-	} else {
-		startBlock = DefaultStartBlocks[chain]
+		return nil, fmt.Errorf("unknown chain %s, see --chain.id or --chains", chain)
 	}
 
 	dbEngin := ctx.String(FlagDbEngin.Name)
@@ -112,24 +201,42 @@ func ParseConfigFromCmd(ctx *cli.Context) (*Config, error) {
 		dataSource = "data/db"
 	}
 
-	blockRange := int64(math.Max(math.Min(5000, float64(ctx.Int64(FlagEthLogsBlockRange.Name))), 1))
+	var entryPoints []EntryPointCfg
+	if ctx.IsSet(FlagEntryPoint.Name) {
+		var err error
+		entryPoints, err = ParseEntryPoints(ctx.String(FlagEntryPoint.Name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var blockRange int64
+	if ctx.IsSet(FlagEthLogsBlockRange.Name) {
+		blockRange = int64(math.Max(math.Min(5000, float64(ctx.Int64(FlagEthLogsBlockRange.Name))), 1))
+	}
 	cfg := &Config{
 		Listen:     ctx.String(FlagListen.Name),
 		GrpcListen: ctx.String(FlagGrpcListen.Name),
 		Chains: []ChainCfg{{
-			Chain:          chain,
-			ChainId:        chainId,
-			Backends:       strings.Split(ctx.String(FlagBackendUrl.Name), ","),
-			StartBlock:     startBlock,
-			BlockRangeSize: blockRange,
+			Chain:           chain,
+			ChainId:         chainId,
+			Backends:        strings.Split(ctx.String(FlagBackendUrl.Name), ","),
+			StartBlock:      ctx.Int64(FlagEthLogsStartBlock.Name),
+			BlockRangeSize:  blockRange,
+			ReorgDepth:      ctx.Int64(FlagReorgDepth.Name),
+			Finality:        ctx.String(FlagFinality.Name),
+			BackfillWorkers: ctx.Int(FlagBackfillWorkers.Name),
+			Balance:         ctx.String(FlagBalance.Name),
+			Quorum:          ctx.Int(FlagQuorum.Name),
 		}},
 		Db: DBCfg{
 			Engin: dbEngin,
 			Ds:    dataSource,
 		},
-		EntryPoints: []string{strings.ToLower(ctx.String(FlagEntryPoint.Name))},
-		Compress:    ctx.Bool(FlagCompress.Name),
-		Readonly:    ctx.Bool(FlagReadonly.Name),
+		EntryPoints:     entryPoints,
+		Compress:        ctx.Bool(FlagCompress.Name),
+		Readonly:        ctx.Bool(FlagReadonly.Name),
+		ShutdownTimeout: ctx.Duration(FlagShutdownTimeout.Name),
 	}
 	return cfg, nil
 }
@@ -153,50 +260,139 @@ func ParseConfigFromFile(ctx *cli.Context) (*Config, error) {
 	return cfg, err
 }
 
+// ParseStoreConfig returns the store of the config file, if any, overridden
+// by the db flags, for the commands that don't need rpc backends.
+func ParseStoreConfig(ctx *cli.Context) (DBCfg, bool) {
+	db := DBCfg{Engin: ctx.String(FlagDbEngin.Name), Ds: ctx.String(FlagDbDataSource.Name)}
+	compress := ctx.Bool(FlagCompress.Name)
+
+	if cfgFile, _ := ParseConfigFromFile(ctx); cfgFile != nil {
+		if !ctx.IsSet(FlagDbEngin.Name) {
+			db.Engin = cfgFile.Db.Engin
+		}
+		if !ctx.IsSet(FlagDbDataSource.Name) {
+			db.Ds = cfgFile.Db.Ds
+		}
+		if !ctx.IsSet(FlagCompress.Name) {
+			compress = cfgFile.Compress
+		}
+	}
+	if db.Engin == "pebble" && len(db.Ds) == 0 {
+		db.Ds = "data/db"
+	}
+	return db, compress
+}
+
 func ParseConfig(ctx *cli.Context) *Config {
 	cfgFile, _ := ParseConfigFromFile(ctx)
-	cfgCmd, _ := ParseConfigFromCmd(ctx)
+
+	registry := ctx.String(FlagChains.Name)
+	if !ctx.IsSet(FlagChains.Name) && cfgFile != nil {
+		registry = cfgFile.ChainRegistry
+	}
+	if len(registry) > 0 {
+		if err := chains.Load(registry); err != nil {
+			panic(fmt.Sprintf("error load chain registry %s: %v", registry, err))
+		}
+	}
+
+	cfgCmd, err := ParseConfigFromCmd(ctx)
 	if cfgFile == nil {
+		if cfgCmd == nil {
+			panic(err)
+		}
 		cfgFile = cfgCmd
-	} else {
-		for idx, _ := range cfgFile.Chains {
-			if cfgFile.Chains[idx].StartBlock <= 0 {
-				cfgFile.Chains[idx].StartBlock = DefaultStartBlocks[cfgFile.Chains[idx].Chain]
-			}
+	} else if cfgCmd != nil {
+		if ctx.IsSet(FlagListen.Name) {
+			cfgFile.Listen = cfgCmd.Listen
+		}
+		if ctx.IsSet(FlagGrpcListen.Name) {
+			cfgFile.GrpcListen = cfgCmd.GrpcListen
+		}
+		if ctx.IsSet(FlagCompress.Name) {
+			cfgFile.Compress = cfgCmd.Compress
+		}
+		if ctx.IsSet(FlagDbEngin.Name) {
+			cfgFile.Db.Engin = cfgCmd.Db.Engin
+		}
+		if ctx.IsSet(FlagDbDataSource.Name) {
+			cfgFile.Db.Ds = cfgCmd.Db.Ds
+		}
 
-			if cfgFile.Chains[idx].BlockRangeSize <= 0 {
-				cfgFile.Chains[idx].BlockRangeSize = 1000
-			}
+		if ctx.IsSet(FlagReadonly.Name) {
+			cfgFile.Readonly = cfgCmd.Readonly
+		}
+		if ctx.IsSet(FlagShutdownTimeout.Name) {
+			cfgFile.ShutdownTimeout = cfgCmd.ShutdownTimeout
+		}
+	}
 
-			//cfgFile.Chains[idx].BlockRangeSize = int64(math.Min(5000, float64(cfgFile.Chains[idx].BlockRangeSize)))
+	for idx := range cfgFile.Chains {
+		cfgFile.Chains[idx].applyRegistry(len(cfgFile.EntryPoints) == 0)
+
+		if cfgFile.Chains[idx].BlockRangeSize <= 0 {
+			cfgFile.Chains[idx].BlockRangeSize = 1000
 		}
 
-		if cfgCmd != nil {
-			if ctx.IsSet(FlagListen.Name) {
-				cfgFile.Listen = cfgCmd.Listen
-			}
-			if ctx.IsSet(FlagGrpcListen.Name) {
-				cfgFile.GrpcListen = cfgCmd.GrpcListen
-			}
-			if ctx.IsSet(FlagCompress.Name) {
-				cfgFile.Compress = cfgCmd.Compress
-			}
-			if ctx.IsSet(FlagDbEngin.Name) {
-				cfgFile.Db.Engin = cfgCmd.Db.Engin
-			}
-			if ctx.IsSet(FlagDbDataSource.Name) {
-				cfgFile.Db.Ds = cfgCmd.Db.Ds
-			}
+		if cfgFile.Chains[idx].ReorgDepth <= 0 {
+			cfgFile.Chains[idx].ReorgDepth = DefaultReorgDepth
+		}
 
-			if ctx.IsSet(FlagReadonly.Name) {
-				cfgFile.Readonly = cfgCmd.Readonly
-			}
+		if err := cfgFile.Chains[idx].validQuorum(); err != nil {
+			panic(err)
 		}
+
+		//cfgFile.Chains[idx].BlockRangeSize = int64(math.Min(5000, float64(cfgFile.Chains[idx].BlockRangeSize)))
 	}
 
 	if len(cfgFile.EntryPoints) == 0 {
 		cfgFile.EntryPoints = DefaultEntryPoints
 	}
+	if cfgFile.ShutdownTimeout <= 0 {
+		cfgFile.ShutdownTimeout = DefaultShutdownTimeout
+	}
 
 	return cfgFile
 }
+
+// validQuorum checks the quorum can be reached with the configured backends.
+func (c *ChainCfg) validQuorum() error {
+	if c.Quorum < 0 || c.Quorum > len(c.Backends) {
+		return fmt.Errorf("chain %s: quorum %v out of range, %v backends configured", c.Chain, c.Quorum, len(c.Backends))
+	}
+	return nil
+}
+
+// applyRegistry fills in what the chain leaves unset from its registry entry:
+// the chain id, the block range, the polling interval and, unless entry points
+// are configured globally, its entry point deployments.
+func (c *ChainCfg) applyRegistry(deployments bool) {
+	entry, ok := chains.Lookup(c.Chain)
+	if !ok {
+		return
+	}
+
+	if c.ChainId == "" {
+		c.ChainId = entry.Id()
+	}
+	if c.BlockRangeSize <= 0 {
+		c.BlockRangeSize = entry.BlockRange
+	}
+	if c.PullingInterval <= 0 {
+		c.PullingInterval = entry.PollInterval
+	}
+
+	if deployments && len(c.EntryPoints) == 0 {
+		if len(entry.EntryPoints) == 0 {
+			// the default entry points aren't deployed there either
+			panic(fmt.Sprintf("chain %s: no entry point deployments in the chain registry, configure its entry points", c.Chain))
+		}
+		for _, deployment := range entry.EntryPoints {
+			ep := EntryPointCfg{Address: deployment.Address, Version: deployment.Version}
+			if err := ep.resolve(); err != nil {
+				panic(fmt.Sprintf("chain %s: %v", c.Chain, err))
+			}
+			c.EntryPoints = append(c.EntryPoints, ep)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cast"
+)
+
+// EntryPointStatus is the indexing progress of an entry point as exposed in
+// /status.
+type EntryPointStatus struct {
+	Address     string `json:"address"`
+	Version     string `json:"version"`
+	BlockNumber int64  `json:"block_number"`
+	CatchingUp  bool   `json:"catching_up"`
+}
+
+// entryPointCursor is where indexing an entry point continues from.
+type entryPointCursor struct {
+	address    common.Address
+	version    string
+	dbKey      string
+	startBlock int64
+	// explicit tells whether the start block was configured for the entry
+	// point rather than inherited from the chain
+	explicit bool
+	// deployBlock is the deploy block from the chain registry, 0 if unknown
+	deployBlock int64
+}
+
+func (c *entryPointCursor) hex() string {
+	return strings.ToLower(c.address.Hex())
+}
+
+// loadCursor reads the stored cursor, which is written together with the logs
+// it covers. v0.6 entry points without an own start block fall back to the
+// cursor the chain had before entry points had their own, rewound by
+// blockRange as it was written apart from the logs. Only v0.6 entry points
+// were indexed then, the others start from their initial block.
+func (b *Backend) loadCursor(ctx context.Context, c *entryPointCursor) (int64, error) {
+	val, err := b.db.Get(c.dbKey, false)
+	if err != nil {
+		return 0, fmt.Errorf("error get db key %s: %w", c.dbKey, err)
+	}
+	if len(val) > 0 {
+		return cast.ToInt64(string(val)), nil
+	}
+
+	if !c.explicit && c.version == entrypoint.V06 {
+		legacyKey := DbKeyChainStartBlock(b.chain)
+		val, err = b.db.Get(legacyKey, false)
+		if err != nil {
+			return 0, fmt.Errorf("error get db key %s: %w", legacyKey, err)
+		}
+		if len(val) > 0 {
+			return int64(math.Max(float64(cast.ToInt64(string(val))-b.blockRange), 0)), nil
+		}
+	}
+	return b.initialBlock(ctx, c)
+}
+
+// loadCursors splits the entry points into the tailing ones, which are
+// indexed together from the lowest of their cursors, and the lagging ones,
+// which catch up on their own until they reach the tailing cursor.
+func (b *Backend) loadCursors(ctx context.Context) error {
+	loaded := map[common.Address]int64{}
+	sorted := append([]*entryPointCursor(nil), b.cursors...)
+	for _, c := range sorted {
+		block, err := b.loadCursor(ctx, c)
+		if err != nil {
+			return err
+		}
+		loaded[c.address] = block
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return loaded[sorted[i].address] > loaded[sorted[j].address]
+	})
+
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+
+	b.tailing = nil
+	b.lagging = map[common.Address]int64{}
+	var tailBlock int64
+	for _, c := range sorted {
+		block := loaded[c.address]
+		if len(b.tailing) == 0 || block >= loaded[sorted[0].address]-b.blockRange {
+			b.tailing = append(b.tailing, c.address)
+			tailBlock = block
+			continue
+		}
+		b.lagging[c.address] = block
+	}
+
+	nexBlockNumberMap.Store(b.chain, tailBlock)
+	gBlockNumberMap.Store(b.chain, tailBlock)
+	b.storeEntryPointStatus()
+	return nil
+}
+
+// storedBlockNumber returns the lowest stored cursor of the entry points of a
+// chain, for servers not running the backend.
+func storedBlockNumber(db database.KVStore, chain string, eps []EntryPointCfg) int64 {
+	var blockNumber int64 = -1
+	for _, ep := range eps {
+		v, _ := db.Get(DbKeyStartBlock(chain, ep.Address), false)
+		if len(v) == 0 {
+			continue
+		}
+		if block := cast.ToInt64(string(v)); blockNumber < 0 || block < blockNumber {
+			blockNumber = block
+		}
+	}
+	if blockNumber < 0 {
+		v, _ := db.Get(DbKeyChainStartBlock(chain), false)
+		blockNumber = cast.ToInt64(string(v))
+	}
+	return blockNumber
+}
+
+// tailingEntryPoints are the entry points indexed by the head-following loop.
+func (b *Backend) tailingEntryPoints() []common.Address {
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+	return append([]common.Address(nil), b.tailing...)
+}
+
+func (b *Backend) cursor(address common.Address) *entryPointCursor {
+	for _, c := range b.cursors {
+		if c.address == address {
+			return c
+		}
+	}
+	return nil
+}
+
+// rewindLagging adds the cursors of the lagging entry points ahead of block,
+// moved back to it after a reorg, to batch and returns these entry points. The
+// caller holds cursorLock.
+func (b *Backend) rewindLagging(batch database.Batch, block int64) []common.Address {
+	var rewound []common.Address
+	for address, cursor := range b.lagging {
+		if cursor > block {
+			batch.Put(b.cursor(address).dbKey, []byte(fmt.Sprintf("%v", block)), false)
+			rewound = append(rewound, address)
+		}
+	}
+	return rewound
+}
+
+// setLagging writes batch together with the cursor of a lagging entry point,
+// which only moves if the write succeeds. The caller holds cursorLock.
+func (b *Backend) setLagging(batch database.Batch, address common.Address, block int64) error {
+	batch.Put(b.cursor(address).dbKey, []byte(fmt.Sprintf("%v", block)), false)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	b.lagging[address] = block
+	b.storeEntryPointStatus()
+	return nil
+}
+
+func (b *Backend) laggingCursor(address common.Address) (int64, bool) {
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+	block, ok := b.lagging[address]
+	return block, ok
+}
+
+func (b *Backend) storeEntryPointStatus() {
+	tailBlock := b.startBlock
+	if v, ok := nexBlockNumberMap.Load(b.chain); ok {
+		tailBlock = v.(int64)
+	}
+
+	var stats []EntryPointStatus
+	for _, c := range b.cursors {
+		stat := EntryPointStatus{Address: c.hex(), Version: c.version, BlockNumber: tailBlock}
+		if block, ok := b.lagging[c.address]; ok {
+			stat.BlockNumber, stat.CatchingUp = block, true
+		}
+		stats = append(stats, stat)
+	}
+	gEntryPointsMap.Store(b.chain, stats)
+}
+
+// catchUp indexes a lagging entry point on its own until it reaches the
+// tailing cursor, then hands it over to the head-following loop. Blocks that
+// may still be reorganized are indexed under the window lock, like the
+// head-following loop does.
+func (b *Backend) catchUp(ctx context.Context, address common.Address) {
+	b.logger.Info("entry point catching up", "entrypoint", address.Hex(), "chain", b.chain)
+
+	for ctx.Err() == nil {
+		fromBlock, ok := b.laggingCursor(address)
+		if !ok {
+			return
+		}
+
+		heads := loadHeads(b.db, b.chain)
+		if heads.Head == 0 {
+			sleep(ctx, _retryInterval)
+			continue
+		}
+
+		safeBlock := int64(math.Min(float64(b.StartBlock()), float64(heads.Head-b.reorgDepth)))
+		if fromBlock < safeBlock {
+			toBlock := int64(math.Min(float64(fromBlock+b.rangeSize.Size()-1), float64(safeBlock)))
+			fetched := b.fetchWindow(ctx, fromBlock, toBlock, []common.Address{address})
+			if fetched == nil {
+				return
+			}
+			if err := b.saveCatchUp(fetched, address, fromBlock, toBlock); err != nil {
+				b.logger.Error("error save catch up logs", "err", err, "entrypoint", address.Hex(), "chain", b.chain)
+				sleep(ctx, _retryInterval)
+				continue
+			}
+			if len(fetched.logs) > 0 {
+				b.logger.Info(fmt.Sprintf("catch up logs range [%v,%v]", fromBlock, toBlock), "size", len(fetched.logs), "entrypoint", address.Hex(), "chain", b.chain)
+			}
+			continue
+		}
+
+		if err := b.join(address); err != nil {
+			b.logger.Error("error join entry point", "err", err, "entrypoint", address.Hex(), "chain", b.chain)
+			sleep(ctx, _retryInterval)
+			continue
+		}
+		b.logger.Info("entry point caught up", "entrypoint", address.Hex(), "chain", b.chain)
+		return
+	}
+}
+
+// saveCatchUp writes the logs of a lagging entry point together with its
+// cursor, unless a reorg moved the cursor in the meantime.
+func (b *Backend) saveCatchUp(fetched *rangeLogs, address common.Address, fromBlock, toBlock int64) error {
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	batch := b.db.NewBatch()
+	if err := b.saveLogs(batch, fetched, nil); err != nil {
+		return err
+	}
+	if err := b.recordRange(batch, []common.Address{address}, fromBlock, toBlock); err != nil {
+		return err
+	}
+
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+	if block, ok := b.lagging[address]; ok && block == fromBlock {
+		return b.setLagging(batch, address, toBlock)
+	}
+	return batch.Write()
+}
+
+// join indexes the last blocks up to the tailing cursor and adds the entry
+// point to the tailing ones, all under the window lock, so the
+// head-following loop can't move in between.
+func (b *Backend) join(address common.Address) error {
+	b.windowLock.Lock()
+	defer b.windowLock.Unlock()
+
+	window, err := b.loadWindow()
+	if err != nil {
+		return err
+	}
+
+	for {
+		fromBlock, ok := b.laggingCursor(address)
+		if !ok {
+			return nil
+		}
+		tailBlock := b.StartBlock()
+		if fromBlock >= tailBlock {
+			break
+		}
+
+		toBlock := int64(math.Min(float64(fromBlock+b.rangeSize.Size()-1), float64(tailBlock)))
+		cli, err := b.pool.Pick(toBlock)
+		if err != nil {
+			return err
+		}
+		ethlogs, err := b.fetchLogs(fromBlock, toBlock, []common.Address{address}, cli)
+		if err != nil {
+			return err
+		}
+		fetched, err := b.enrichRange(fromBlock, toBlock, ethlogs, cli)
+		if err != nil {
+			return err
+		}
+		if err := b.saveJoin(fetched, window, address, fromBlock, toBlock); err != nil {
+			b.window = nil
+			return err
+		}
+	}
+
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+	delete(b.lagging, address)
+	b.tailing = append(b.tailing, address)
+	b.storeEntryPointStatus()
+
+	select {
+	case b.joined <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// saveJoin writes the logs of a joining entry point together with the reorg
+// window and its cursor, the caller holds windowLock.
+func (b *Backend) saveJoin(fetched *rangeLogs, window *blockWindow, address common.Address, fromBlock, toBlock int64) error {
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	batch := b.db.NewBatch()
+	if err := b.saveLogs(batch, fetched, window); err != nil {
+		return err
+	}
+	if err := b.recordRange(batch, []common.Address{address}, fromBlock, toBlock); err != nil {
+		return err
+	}
+	if err := b.saveWindow(batch); err != nil {
+		return err
+	}
+
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+	return b.setLagging(batch, address, toBlock)
+}
@@ -0,0 +1,40 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestLoadCursorLegacy(t *testing.T) {
+	b := newStoreBackend("ethereum", memorydb.New(), false)
+	b.blockRange = 100
+	if err := b.db.Put(DbKeyChainStartBlock(b.chain), []byte("5000"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		cursor *entryPointCursor
+		want   int64
+	}{
+		{"v0.6 continues from the chain cursor", &entryPointCursor{version: entrypoint.V06, deployBlock: 1000}, 4900},
+		{"v0.6 with a start block", &entryPointCursor{version: entrypoint.V06, startBlock: 3000, explicit: true}, 3000},
+		{"v0.7 starts from its deploy block", &entryPointCursor{version: entrypoint.V07, deployBlock: 2000}, 2000},
+		{"v0.8 starts from the chain start block", &entryPointCursor{version: entrypoint.V08, deployBlock: 2000, startBlock: 2500}, 2500},
+	}
+	for _, c := range cases {
+		c.cursor.address = common.BigToAddress(common.Big1)
+		c.cursor.dbKey = DbKeyStartBlock(b.chain, c.cursor.hex())
+		got, err := b.loadCursor(context.Background(), c.cursor)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: cursor %v, want %v", c.name, got, c.want)
+		}
+	}
+}
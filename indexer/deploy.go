@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cast"
+)
+
+const _deployBlockAttempts = 3
+
+var errNotDeployed = errors.New("entry point not deployed")
+
+// findDeployBlock binary searches the first block at which address has code.
+// It needs a backend serving historical state.
+func findDeployBlock(cli *web3.Web3, address common.Address, head int64) (int64, error) {
+	hasCode := func(blockNumber int64) (bool, error) {
+		ctx, cancelFunc := context.WithTimeout(context.Background(), _httpTimeout)
+		defer cancelFunc()
+		code, err := cli.Cli().CodeAt(ctx, address, big.NewInt(blockNumber))
+		if err != nil {
+			return false, fmt.Errorf("error get code at block %v: %w", blockNumber, err)
+		}
+		return len(code) > 0, nil
+	}
+
+	deployed, err := hasCode(head)
+	if err != nil {
+		return 0, err
+	}
+	if !deployed {
+		return 0, errNotDeployed
+	}
+
+	low, high := int64(0), head
+	for low < high {
+		mid := low + (high-low)/2
+		deployed, err = hasCode(mid)
+		if err != nil {
+			return 0, err
+		}
+		if deployed {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	return low, nil
+}
+
+// deployBlock returns the block an entry point was deployed at, taken from
+// the chain registry or else searched once per chain and then stored.
+func (b *Backend) deployBlock(c *entryPointCursor) (int64, error) {
+	if c.deployBlock > 0 {
+		return c.deployBlock, nil
+	}
+
+	dbKey := DbKeyDeployBlock(b.chain, c.hex())
+	val, err := b.db.Get(dbKey, false)
+	if err != nil {
+		return 0, fmt.Errorf("error get db key %s: %w", dbKey, err)
+	}
+	if len(val) > 0 {
+		return cast.ToInt64(string(val)), nil
+	}
+
+	head, err := b.pool.Heads()
+	if err != nil {
+		return 0, err
+	}
+	cli, err := b.pool.Pick(0)
+	if err != nil {
+		return 0, err
+	}
+
+	startTime := time.Now()
+	block, err := findDeployBlock(cli, c.address, int64(head))
+	if err != nil {
+		return int64(head), err
+	}
+	b.logger.Info("found entry point deploy block", "entrypoint", c.address.Hex(), "block", block, "elapsed", time.Since(startTime), "chain", b.chain)
+
+	if err := b.db.Put(dbKey, []byte(fmt.Sprintf("%v", block)), false); err != nil {
+		return 0, fmt.Errorf("error put db key %s: %w", dbKey, err)
+	}
+	return block, nil
+}
+
+// initialBlock is where indexing an entry point without a stored cursor
+// starts: its configured start block or, if it has none, the later of the
+// chain start block and its deploy block. An entry point not deployed yet
+// starts at the head. If the deploy block can't be found it starts at the
+// chain start block, or fails if there is none rather than scan from genesis.
+func (b *Backend) initialBlock(ctx context.Context, c *entryPointCursor) (int64, error) {
+	if c.explicit {
+		return c.startBlock, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		block, err := b.deployBlock(c)
+		if errors.Is(err, errNotDeployed) {
+			b.logger.Warn("entry point has no code, starting at the head", "entrypoint", c.address.Hex(), "block", block, "chain", b.chain)
+			return block, nil
+		}
+		if err == nil {
+			return max(block, c.startBlock), nil
+		}
+
+		b.logger.Warn("error find entry point deploy block", "err", err, "attempt", attempt, "entrypoint", c.address.Hex(), "chain", b.chain)
+		if attempt >= _deployBlockAttempts {
+			if c.startBlock > 0 {
+				return c.startBlock, nil
+			}
+			return 0, fmt.Errorf("entry point %s: no start block configured and its deploy block not found: %w", c.address.Hex(), err)
+		}
+		if !sleep(ctx, _retryInterval) {
+			return 0, ctx.Err()
+		}
+	}
+}
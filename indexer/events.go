@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Key spaces of the indexed entry point events.
+const (
+	SpaceUserOp            = "op"
+	SpaceAccountDeployed   = "deployed"
+	SpaceRevertReason      = "revert"
+	SpaceBeforeExecution   = "before-exec"
+	SpaceDeposited         = "deposited"
+	SpaceWithdrawn         = "withdrawn"
+	SpaceStakeLocked       = "stake-locked"
+	SpaceStakeUnlocked     = "stake-unlocked"
+	SpaceStakeWithdrawn    = "stake-withdrawn"
+	SpaceAggregatorChanged = "aggregator"
+	SpacePostOpRevert      = "postop-revert"
+	SpacePrefundTooLow     = "prefund-too-low"
+	SpaceIgnoredInitCode   = "ignored-initcode"
+)
+
+const (
+	eventKeyUserOpHash      = "userOpHash"
+	eventKeyAddress         = "address"
+	eventKeyTransactionHash = "txHash"
+)
+
+// eventSpace is the key space an entry point event is stored in. Events keyed
+// by user operation hash hold a single log per key, the others a list of logs
+// ordered by block number and log index.
+type eventSpace struct {
+	Name  string
+	Topic common.Hash
+	Space string
+	KeyBy string
+}
+
+func (e *eventSpace) Multi() bool {
+	return e.KeyBy != eventKeyUserOpHash
+}
+
+var (
+	// EntryPointAbi holds the events of all entry point versions
+	EntryPointAbi = abi.ABI{Events: entrypoint.Events()}
+
+	_eventSpaces = []*eventSpace{
+		{Name: "UserOperationEvent", Space: SpaceUserOp, KeyBy: eventKeyUserOpHash},
+		{Name: "AccountDeployed", Space: SpaceAccountDeployed, KeyBy: eventKeyUserOpHash},
+		{Name: "UserOperationRevertReason", Space: SpaceRevertReason, KeyBy: eventKeyUserOpHash},
+		{Name: "BeforeExecution", Space: SpaceBeforeExecution, KeyBy: eventKeyTransactionHash},
+		{Name: "Deposited", Space: SpaceDeposited, KeyBy: eventKeyAddress},
+		{Name: "Withdrawn", Space: SpaceWithdrawn, KeyBy: eventKeyAddress},
+		{Name: "StakeLocked", Space: SpaceStakeLocked, KeyBy: eventKeyAddress},
+		{Name: "StakeUnlocked", Space: SpaceStakeUnlocked, KeyBy: eventKeyAddress},
+		{Name: "StakeWithdrawn", Space: SpaceStakeWithdrawn, KeyBy: eventKeyAddress},
+		{Name: "SignatureAggregatorChanged", Space: SpaceAggregatorChanged, KeyBy: eventKeyAddress},
+		{Name: "PostOpRevertReason", Space: SpacePostOpRevert, KeyBy: eventKeyUserOpHash},
+		{Name: "UserOperationPrefundTooLow", Space: SpacePrefundTooLow, KeyBy: eventKeyUserOpHash},
+		{Name: "IgnoredInitCode", Space: SpaceIgnoredInitCode, KeyBy: eventKeyUserOpHash},
+	}
+	_eventSpacesByTopic = map[common.Hash]*eventSpace{}
+)
+
+func init() {
+	var topics []common.Hash
+	for _, space := range _eventSpaces {
+		space.Topic = EntryPointAbi.Events[space.Name].ID
+		_eventSpacesByTopic[space.Topic] = space
+		topics = append(topics, space.Topic)
+	}
+	_logTopics = [][]common.Hash{topics}
+}
+
+func topicAddress(topic common.Hash) string {
+	return strings.ToLower(common.BytesToAddress(topic.Bytes()).Hex())
+}
+
+// eventKey returns the db key a log is stored under.
+func eventKey(chain string, ethlog *types.Log) (string, bool) {
+	if len(ethlog.Topics) == 0 {
+		return "", false
+	}
+	space, ok := _eventSpacesByTopic[ethlog.Topics[0]]
+	if !ok {
+		return "", false
+	}
+
+	switch space.KeyBy {
+	case eventKeyUserOpHash:
+		if len(ethlog.Topics) < 2 {
+			return "", false
+		}
+		return DbKeyEvent(chain, space.Space, ethlog.Topics[1].Hex()), true
+	case eventKeyAddress:
+		if len(ethlog.Topics) < 2 {
+			return "", false
+		}
+		return DbKeyEventLog(chain, space.Space, topicAddress(ethlog.Topics[1]), ethlog.BlockNumber, ethlog.Index), true
+	case eventKeyTransactionHash:
+		return DbKeyEventLog(chain, space.Space, ethlog.TxHash.Hex(), ethlog.BlockNumber, ethlog.Index), true
+	}
+	panic(fmt.Sprintf("invalid event key %s", space.KeyBy))
+}
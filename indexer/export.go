@@ -0,0 +1,536 @@
+package indexer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/golang/snappy"
+)
+
+const (
+	FormatJsonl = "jsonl"
+	FormatCsv   = "csv"
+
+	_exportProgress = 10000
+	_importBatch    = 1000
+)
+
+var _csvHeader = []string{"chain", "name", "address", "blockNumber", "blockHash", "transactionHash", "transactionIndex", "logIndex", "topics", "data", "event", "userOperation", "receipt", "bundle"}
+
+// ExportRecord is a line of an export: a stored log, decoded where possible,
+// with the records that can't be derived from it again, or the cursor and the
+// ledger of an entry point.
+type ExportRecord struct {
+	Chain string     `json:"chain"`
+	Name  string     `json:"name,omitempty"`
+	Log   *types.Log `json:"log,omitempty"`
+	// Event is the decoded log, the stored UserOperationEvent for user
+	// operations
+	Event         json.RawMessage `json:"event,omitempty"`
+	UserOperation json.RawMessage `json:"userOperation,omitempty"`
+	Receipt       json.RawMessage `json:"receipt,omitempty"`
+	Bundle        json.RawMessage `json:"bundle,omitempty"`
+
+	EntryPoint    *common.Address `json:"entryPoint,omitempty"`
+	Cursor        *int64          `json:"cursor,omitempty"`
+	IndexedRanges []BlockRange    `json:"indexedRanges,omitempty"`
+}
+
+// ParseFormat checks an export format, a file name ending in .csv defaults to
+// csv and anything else to jsonl.
+func ParseFormat(format, file string) (string, error) {
+	switch format {
+	case FormatJsonl, FormatCsv:
+		return format, nil
+	case "":
+		if strings.HasSuffix(strings.ToLower(file), ".csv") {
+			return FormatCsv, nil
+		}
+		return FormatJsonl, nil
+	}
+	return "", fmt.Errorf("invalid format %s, expect %s or %s", format, FormatJsonl, FormatCsv)
+}
+
+type recordWriter interface {
+	Write(record *ExportRecord) error
+	Flush() error
+}
+
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Write(record *ExportRecord) error {
+	return w.enc.Encode(record)
+}
+
+func (w *jsonlWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// csvWriter writes the logs only, a csv export carries no cursors.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvWriter) Write(record *ExportRecord) error {
+	if record.Log == nil {
+		return nil
+	}
+	var topics []string
+	for _, topic := range record.Log.Topics {
+		topics = append(topics, topic.Hex())
+	}
+	return w.w.Write([]string{
+		record.Chain,
+		record.Name,
+		record.Log.Address.Hex(),
+		strconv.FormatUint(record.Log.BlockNumber, 10),
+		record.Log.BlockHash.Hex(),
+		record.Log.TxHash.Hex(),
+		strconv.FormatUint(uint64(record.Log.TxIndex), 10),
+		strconv.FormatUint(uint64(record.Log.Index), 10),
+		strings.Join(topics, ";"),
+		hexutil.Encode(record.Log.Data),
+		string(record.Event),
+		string(record.UserOperation),
+		string(record.Receipt),
+		string(record.Bundle),
+	})
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func newRecordWriter(format string, w io.Writer) (recordWriter, error) {
+	if format == FormatCsv {
+		cw := csv.NewWriter(w)
+		return &csvWriter{w: cw}, cw.Write(_csvHeader)
+	}
+	bw := bufio.NewWriter(w)
+	return &jsonlWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+}
+
+// Export writes the logs stored for a chain in [fromBlock, toBlock] to w,
+// followed by the cursors and the ledgers of its entry points, cut to the
+// range. A negative fromBlock or toBlock leaves the range open on that side.
+// The logs are written in key order, not in block order. It returns the number
+// of exported logs.
+func Export(db database.KVStore, compress bool, chain string, fromBlock, toBlock int64, format string, w io.Writer) (int, error) {
+	logger := log.Module("export")
+	out, err := newRecordWriter(format, w)
+	if err != nil {
+		return 0, err
+	}
+
+	get := func(key string) ([]byte, error) {
+		data, err := db.Get(key, compress)
+		if err != nil || data == nil || !compress {
+			return data, err
+		}
+		return snappy.Decode(nil, data)
+	}
+
+	var count int
+	for _, space := range _eventSpaces {
+		var exportErr error
+		err := db.Iterate(DbKeyEvent(chain, space.Space, ""), "", compress, func(key string, data []byte) bool {
+			if compress {
+				if data, exportErr = snappy.Decode(nil, data); exportErr != nil {
+					exportErr = fmt.Errorf("error decode db key %s: %w", key, exportErr)
+					return false
+				}
+			}
+			ethlog := &types.Log{}
+			if exportErr = json.Unmarshal(data, ethlog); exportErr != nil {
+				exportErr = fmt.Errorf("error decode db key %s: %w", key, exportErr)
+				return false
+			}
+			blockNumber := int64(ethlog.BlockNumber)
+			if (fromBlock >= 0 && blockNumber < fromBlock) || (toBlock >= 0 && blockNumber > toBlock) {
+				return true
+			}
+
+			record := &ExportRecord{Chain: chain, Name: space.Name, Log: ethlog}
+			if isUserOperationEvent(ethlog) {
+				opHash := ethlog.Topics[1].Hex()
+				for _, field := range []struct {
+					value *json.RawMessage
+					key   string
+				}{
+					{&record.Event, DbKeyEvent(chain, SpaceUserOpEvent, opHash)},
+					{&record.UserOperation, DbKeyEvent(chain, SpaceUserOperation, opHash)},
+					{&record.Receipt, DbKeyEvent(chain, SpaceReceipt, ethlog.TxHash.Hex())},
+					{&record.Bundle, DbKeyEvent(chain, SpaceBundle, ethlog.TxHash.Hex())},
+				} {
+					if *field.value, exportErr = get(field.key); exportErr != nil {
+						exportErr = fmt.Errorf("error get db key %s: %w", field.key, exportErr)
+						return false
+					}
+				}
+			} else if args, err := decodeEventArgs(ethlog); err == nil {
+				record.Event, _ = json.Marshal(args)
+			}
+
+			if exportErr = out.Write(record); exportErr != nil {
+				return false
+			}
+			count++
+			if count%_exportProgress == 0 {
+				logger.Info("exported logs", "count", count, "chain", chain)
+			}
+			return true
+		})
+		if err == nil {
+			err = exportErr
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+
+	records, err := exportCursors(db, chain, fromBlock, toBlock)
+	if err != nil {
+		return count, err
+	}
+	for _, record := range records {
+		if err := out.Write(record); err != nil {
+			return count, err
+		}
+	}
+	return count, out.Flush()
+}
+
+// exportCursors returns the cursors and the ledgers of the entry points of a
+// chain, cut to [fromBlock, toBlock]. A cursor is only exported if the range
+// starts at or below the first indexed block of its entry point, otherwise
+// the blocks below the range would be taken as indexed by the importing store.
+func exportCursors(db database.KVStore, chain string, fromBlock, toBlock int64) ([]*ExportRecord, error) {
+	var records []*ExportRecord
+	prefix := DbKeyStartBlock(chain, "")
+	err := db.Iterate(prefix, "", false, func(key string, data []byte) bool {
+		cursor, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return true
+		}
+		if toBlock >= 0 && cursor > toBlock {
+			cursor = toBlock
+		}
+		address := common.HexToAddress(strings.TrimPrefix(key, prefix))
+		records = append(records, &ExportRecord{Chain: chain, EntryPoint: &address, Cursor: &cursor})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ExportRecord
+	for _, record := range records {
+		ranges, err := loadRanges(db, DbKeyIndexedRanges(chain, record.EntryPoint.Hex()))
+		if err != nil {
+			return nil, err
+		}
+		if fromBlock > 0 && (len(ranges) == 0 || ranges[0].From < fromBlock) {
+			record.Cursor = nil
+		}
+		if toBlock >= 0 {
+			ranges = ranges.truncate(toBlock)
+		}
+		for _, r := range ranges {
+			if fromBlock >= 0 && r.To < fromBlock {
+				continue
+			}
+			r.From = max(r.From, fromBlock)
+			record.IndexedRanges = append(record.IndexedRanges, r)
+		}
+		if record.Cursor != nil || len(record.IndexedRanges) > 0 {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+// decodeEventArgs decodes the arguments of an entry point log.
+func decodeEventArgs(ethlog *types.Log) (map[string]any, error) {
+	if len(ethlog.Topics) == 0 {
+		return nil, errors.New("log without topics")
+	}
+	event, err := EntryPointAbi.EventByID(ethlog.Topics[0])
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]any{}
+	if err := event.Inputs.UnpackIntoMap(args, ethlog.Data); err != nil {
+		return nil, err
+	}
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	if err := abi.ParseTopicsIntoMap(args, indexed, ethlog.Topics[1:]); err != nil {
+		return nil, err
+	}
+
+	for name, value := range args {
+		switch v := value.(type) {
+		case *big.Int:
+			args[name] = (*hexutil.Big)(v)
+		case [32]byte:
+			args[name] = common.Hash(v)
+		case []byte:
+			args[name] = hexutil.Bytes(v)
+		}
+	}
+	return args, nil
+}
+
+// readRecords calls fn for every record of an export read from r.
+func readRecords(format string, r io.Reader, fn func(record *ExportRecord) error) error {
+	if format != FormatCsv {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 64<<20)
+		for line := 1; scanner.Scan(); line++ {
+			if len(strings.TrimSpace(scanner.Text())) == 0 {
+				continue
+			}
+			record := &ExportRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+				return fmt.Errorf("line %v: %w", line, err)
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(_csvHeader)
+	if _, err := reader.Read(); err != nil {
+		return err
+	}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		record, err := parseCsvRecord(row)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("line %v: %w", line, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+func parseCsvRecord(row []string) (*ExportRecord, error) {
+	ethlog := &types.Log{
+		Address:   common.HexToAddress(row[2]),
+		BlockHash: common.HexToHash(row[4]),
+		TxHash:    common.HexToHash(row[5]),
+	}
+	var err error
+	if ethlog.BlockNumber, err = strconv.ParseUint(row[3], 10, 64); err != nil {
+		return nil, err
+	}
+	txIndex, err := strconv.ParseUint(row[6], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	logIndex, err := strconv.ParseUint(row[7], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	ethlog.TxIndex, ethlog.Index = uint(txIndex), uint(logIndex)
+	for _, topic := range strings.Split(row[8], ";") {
+		if len(topic) > 0 {
+			ethlog.Topics = append(ethlog.Topics, common.HexToHash(topic))
+		}
+	}
+	if ethlog.Data, err = hexutil.Decode(row[9]); err != nil {
+		return nil, err
+	}
+
+	record := &ExportRecord{Chain: row[0], Name: row[1], Log: ethlog}
+	for i, value := range []*json.RawMessage{&record.Event, &record.UserOperation, &record.Receipt, &record.Bundle} {
+		if len(row[10+i]) > 0 {
+			*value = json.RawMessage(row[10+i])
+		}
+	}
+	return record, nil
+}
+
+// Import loads an export into db. The logs are indexed again, which rebuilds
+// the records derived from them, the other records are written as exported.
+// The cursors of entry points that have none are set and the ledgers are
+// merged, a csv export has neither. It returns the number of imported logs.
+func Import(db database.KVStore, compress bool, format string, r io.Reader) (int, error) {
+	logger := log.Module("import")
+	if format == FormatCsv {
+		logger.Warn("a csv export carries no cursors, the store indexes the imported chains from their start")
+	}
+	backends := map[string]*Backend{}
+	pending := map[string][]*ExportRecord{}
+	var count, size int
+
+	flush := func() error {
+		if size == 0 {
+			return nil
+		}
+		for chain, records := range pending {
+			if err := backends[chain].importLogs(records); err != nil {
+				return err
+			}
+			count += len(records)
+		}
+		pending, size = map[string][]*ExportRecord{}, 0
+		logger.Info("imported logs", "count", count)
+		return nil
+	}
+
+	err := readRecords(format, r, func(record *ExportRecord) error {
+		if len(record.Chain) == 0 {
+			return errors.New("record without chain")
+		}
+		b, ok := backends[record.Chain]
+		if !ok {
+			b = newStoreBackend(record.Chain, db, compress)
+			// the indexer of the store may be running, it applies the
+			// paymaster totals
+			b.deferStats = true
+			backends[record.Chain] = b
+		}
+
+		if record.Log == nil {
+			if err := flush(); err != nil {
+				return err
+			}
+			return b.importCursor(record)
+		}
+		pending[record.Chain] = append(pending[record.Chain], record)
+		size++
+		if size >= _importBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, flush()
+}
+
+// newStoreBackend returns a backend of a chain without rpc backends, which
+// can only write to the store outside the cursors.
+func newStoreBackend(chain string, db database.KVStore, compress bool) *Backend {
+	return &Backend{
+		chain:    chain,
+		ranged:   true,
+		db:       db,
+		compress: compress,
+		versions: map[common.Address]*entrypoint.EntryPoint{},
+		logger:   log.Module("backend"),
+	}
+}
+
+func (b *Backend) importLogs(records []*ExportRecord) error {
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	fetched := &rangeLogs{
+		timestamps:   map[common.Hash]uint64{},
+		transactions: map[common.Hash]*bundleTx{},
+		userOps:      map[common.Hash][]*entrypoint.UserOperation{},
+		receipts:     map[common.Hash]json.RawMessage{},
+	}
+	for _, record := range records {
+		ethlog := *record.Log
+		ethlog.Removed = false
+		fetched.logs = append(fetched.logs, ethlog)
+		if !isUserOperationEvent(&ethlog) {
+			continue
+		}
+		if len(record.Event) > 0 {
+			event := &UserOperationEvent{}
+			if err := json.Unmarshal(record.Event, event); err == nil {
+				fetched.timestamps[ethlog.BlockHash] = uint64(event.BlockTimestamp)
+			}
+		}
+		if len(record.Receipt) > 0 {
+			fetched.receipts[ethlog.TxHash] = record.Receipt
+		}
+	}
+
+	batch := b.db.NewBatch()
+	if err := b.saveLogs(batch, fetched, nil); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if !isUserOperationEvent(record.Log) {
+			continue
+		}
+		if len(record.UserOperation) > 0 {
+			if err := b.put(batch, DbKeyEvent(b.chain, SpaceUserOperation, record.Log.Topics[1].Hex()), record.UserOperation); err != nil {
+				return err
+			}
+		}
+		if len(record.Bundle) > 0 {
+			if err := b.put(batch, DbKeyEvent(b.chain, SpaceBundle, record.Log.TxHash.Hex()), record.Bundle); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Write()
+}
+
+func (b *Backend) importCursor(record *ExportRecord) error {
+	if record.EntryPoint == nil {
+		return errors.New("record without log or entry point")
+	}
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	batch := b.db.NewBatch()
+	if record.Cursor != nil {
+		key := DbKeyStartBlock(b.chain, record.EntryPoint.Hex())
+		ok, err := batch.Has(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			batch.Put(key, []byte(fmt.Sprintf("%v", *record.Cursor)), false)
+		}
+	}
+	for _, r := range record.IndexedRanges {
+		if err := b.recordRange(batch, []common.Address{*record.EntryPoint}, r.From, r.To); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
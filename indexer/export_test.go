@@ -0,0 +1,130 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func dumpStore(t *testing.T, db database.KVStore, skip ...string) map[string]string {
+	dump := map[string]string{}
+	err := db.Iterate("", "", false, func(key string, value []byte) bool {
+		for _, prefix := range skip {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		dump[key] = string(value)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dump
+}
+
+func TestExportImport(t *testing.T) {
+	entryPoint := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	event := EntryPointAbi.Events["UserOperationEvent"]
+	data, err := event.Inputs.NonIndexed().Pack(big.NewInt(1), true, big.NewInt(1000), big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ethlog := &types.Log{
+		Address:     entryPoint,
+		Topics:      []common.Hash{event.ID, common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")},
+		Data:        data,
+		BlockNumber: 120,
+		BlockHash:   common.HexToHash("0x0a"),
+		TxHash:      common.HexToHash("0x0b"),
+		Index:       4,
+	}
+
+	src := memorydb.New()
+	b := newStoreBackend("test", src, false)
+	if err := b.importLogs([]*ExportRecord{{Chain: "test", Log: ethlog, UserOperation: []byte(`{"sender":"0x02"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	src.Put(DbKeyStartBlock("test", entryPoint.Hex()), []byte("150"), false)
+	batch := src.NewBatch()
+	b.recordRange(batch, []common.Address{entryPoint}, 100, 150)
+	batch.Write()
+
+	for _, format := range []string{FormatJsonl, FormatCsv} {
+		var buf bytes.Buffer
+		count, err := Export(src, false, "test", -1, -1, format, &buf)
+		if err != nil || count != 1 {
+			t.Fatalf("%s export = %v, %v, want 1 log", format, count, err)
+		}
+
+		dst := memorydb.New()
+		count, err = Import(dst, false, format, &buf)
+		if err != nil || count != 1 {
+			t.Fatalf("%s import = %v, %v, want 1 log", format, count, err)
+		}
+		if n := deferredChanges(t, dst); n != 1 {
+			t.Fatalf("%s import deferred %v paymaster changes, want 1", format, n)
+		}
+		batch := dst.NewBatch()
+		if err := newStoreBackend("test", dst, false).applyPaymasterChanges(batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Write(); err != nil {
+			t.Fatal(err)
+		}
+
+		var skip []string
+		if format == FormatCsv {
+			skip = []string{"start-block:", "indexed-ranges:"}
+		}
+		want, got := dumpStore(t, src, skip...), dumpStore(t, dst, skip...)
+		if len(got) != len(want) {
+			t.Fatalf("%s import has %v keys, want %v", format, len(got), len(want))
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Errorf("%s import %s = %s, want %s", format, key, got[key], value)
+			}
+		}
+	}
+}
+
+func TestExportCursors(t *testing.T) {
+	entryPoint := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	db := memorydb.New()
+	db.Put(DbKeyStartBlock("test", entryPoint.Hex()), []byte("150"), false)
+	batch := db.NewBatch()
+	newStoreBackend("test", db, false).recordRange(batch, []common.Address{entryPoint}, 100, 150)
+	batch.Write()
+
+	cases := []struct {
+		fromBlock, toBlock int64
+		cursor             string
+		ranges             string
+	}{
+		{-1, -1, "150", "[[100,150]]"},
+		{100, 140, "140", "[[100,140]]"},
+		// the blocks below the range aren't exported, nor is the cursor
+		{120, 140, "<nil>", "[[120,140]]"},
+	}
+	for _, c := range cases {
+		records, err := exportCursors(db, "test", c.fromBlock, c.toBlock)
+		if err != nil || len(records) != 1 {
+			t.Fatalf("exportCursors(%v, %v) = %v, %v", c.fromBlock, c.toBlock, len(records), err)
+		}
+		cursor := "<nil>"
+		if records[0].Cursor != nil {
+			cursor = fmt.Sprint(*records[0].Cursor)
+		}
+		if ranges := fmt.Sprint(records[0].IndexedRanges); cursor != c.cursor || ranges != c.ranges {
+			t.Errorf("exportCursors(%v, %v) = cursor %s ranges %s, want %s %s", c.fromBlock, c.toBlock, cursor, ranges, c.cursor, c.ranges)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	FinalityLatest    = "latest"
+	FinalitySafe      = "safe"
+	FinalityFinalized = "finalized"
+	// FinalityConfirmed is the level of blocks with the configured number of
+	// confirmations, it is not a finality guarantee of the chain
+	FinalityConfirmed = "confirmed"
+)
+
+// ParseFinality parses a ChainCfg.Finality setting, which is either one of the
+// block tags latest, safe and finalized, or a fixed number of confirmations.
+func ParseFinality(finality string) (string, int64, error) {
+	finality = strings.ToLower(strings.TrimSpace(finality))
+	switch finality {
+	case "", FinalityLatest:
+		return FinalityLatest, 0, nil
+	case FinalitySafe, FinalityFinalized:
+		return finality, 0, nil
+	}
+
+	confirmations, err := strconv.ParseInt(finality, 10, 64)
+	if err != nil || confirmations < 0 {
+		return "", 0, fmt.Errorf("invalid finality '%s', allowed 'latest', 'safe', 'finalized' or a number of confirmations", finality)
+	}
+	return FinalityLatest, confirmations, nil
+}
+
+// chainHeads are the heads of a chain at each finality level, and the head
+// the backend indexes up to. Confirmed is the latest block with the configured
+// number of confirmations, 0 without.
+type chainHeads struct {
+	Head      int64 `json:"head"`
+	Latest    int64 `json:"latest"`
+	Safe      int64 `json:"safe"`
+	Finalized int64 `json:"finalized"`
+	Confirmed int64 `json:"confirmed,omitempty"`
+}
+
+// Finality returns the finality level a block has reached.
+func (h chainHeads) Finality(blockNumber int64) string {
+	if blockNumber <= h.Finalized {
+		return FinalityFinalized
+	}
+	if blockNumber <= h.Safe {
+		return FinalitySafe
+	}
+	if blockNumber <= h.Confirmed {
+		return FinalityConfirmed
+	}
+	return FinalityLatest
+}
+
+func loadHeads(db database.KVStore, chain string) chainHeads {
+	var heads chainHeads
+	v, ok := gHeadsMap.Load(chain)
+	if ok {
+		return v.(chainHeads)
+	}
+
+	val, _ := db.Get(DbKeyHeads(chain), false)
+	if len(val) > 0 {
+		json.Unmarshal(val, &heads)
+	}
+	return heads
+}
+
+func tagBlockNumber(ctx context.Context, cli *web3.Web3, tag rpc.BlockNumber) (int64, error) {
+	header, err := cli.Cli().HeaderByNumber(ctx, big.NewInt(tag.Int64()))
+	if err != nil {
+		return 0, err
+	}
+	return header.Number.Int64(), nil
+}
+
+// updateHeads refreshes the safe, finalized and confirmed heads of the chain
+// and returns the head the backend indexes up to. The safe and finalized heads
+// stay 0 on chains without these block tags.
+func (b *Backend) updateHeads(latest int64, cli *web3.Web3) (int64, error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), _httpTimeout)
+	defer cancelFunc()
+
+	heads := chainHeads{Latest: latest}
+	if b.confirmations > 0 {
+		heads.Confirmed = latest - b.confirmations
+	}
+
+	safe, err := tagBlockNumber(ctx, cli, rpc.SafeBlockNumber)
+	if err == nil {
+		heads.Safe = safe
+	} else if b.finality == FinalitySafe {
+		return 0, fmt.Errorf("error get safe block: %w", err)
+	}
+
+	finalized, err := tagBlockNumber(ctx, cli, rpc.FinalizedBlockNumber)
+	if err == nil {
+		heads.Finalized = finalized
+	} else if b.finality == FinalityFinalized {
+		return 0, fmt.Errorf("error get finalized block: %w", err)
+	}
+
+	switch b.finality {
+	case FinalitySafe:
+		heads.Head = heads.Safe
+	case FinalityFinalized:
+		heads.Head = heads.Finalized
+	default:
+		heads.Head = latest - b.confirmations
+	}
+
+	v, ok := gHeadsMap.Load(b.chain)
+	if !ok || v.(chainHeads) != heads {
+		gHeadsMap.Store(b.chain, heads)
+		data, _ := json.Marshal(heads)
+		if err := b.db.Put(DbKeyHeads(b.chain), data, false); err != nil {
+			return 0, err
+		}
+	}
+
+	return heads.Head, nil
+}
+
+// withFinality adds the finality level of a stored log to its json.
+func withFinality(data []byte, heads chainHeads) []byte {
+	if len(data) == 0 || data[len(data)-1] != '}' {
+		return data
+	}
+
+	info := struct {
+		BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	}{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return data
+	}
+
+	level := heads.Finality(int64(info.BlockNumber))
+	result := make([]byte, 0, len(data)+len(level)+16)
+	result = append(result, data[:len(data)-1]...)
+	result = append(result, `,"finality":"`...)
+	result = append(result, level...)
+	result = append(result, `"}`...)
+	return result
+}
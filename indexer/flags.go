@@ -1,15 +1,9 @@
 package indexer
 
 import (
-	"encoding/json"
 	"github.com/urfave/cli/v2"
 )
 
-func _mustMarshal(src any) []byte {
-	result, _ := json.Marshal(src)
-	return result
-}
-
 var (
 	FlagVersion = &cli.BoolFlag{
 		Name:  "version",
@@ -48,14 +42,20 @@ var (
 
 	FlagChainId = &cli.StringFlag{
 		Name:  "chain.id",
-		Usage: string(_mustMarshal(DefaultChainId)),
+		Usage: "Chain id, looked up in the chain registry if not set",
+		Value: "",
+	}
+
+	FlagChains = &cli.StringFlag{
+		Name:  "chains",
+		Usage: "Chain registry json file or directory, its entries override the built-in ones by name",
 		Value: "",
 	}
 
 	FlagEntryPoint = &cli.StringFlag{
 		Name:  "entrypoint",
-		Usage: "Entrypoint contract",
-		Value: "0x5ff137d4b0fdcd49dca30c7cf57e578a026d2789",
+		Usage: "Entrypoint contracts, comma separated, each optionally tagged with its version as address@version, the chain's deployments in the chain registry, or the two v0.6 entry points, if not set",
+		Value: "",
 	}
 
 	FlagBackendUrl = &cli.StringFlag{
@@ -64,6 +64,18 @@ var (
 		Value: "",
 	}
 
+	FlagBalance = &cli.StringFlag{
+		Name:  "backend.balance",
+		Usage: "Backend selection: 'score' picks the healthiest, 'weighted' spreads requests in a weighted round-robin",
+		Value: BalanceScore,
+	}
+
+	FlagQuorum = &cli.IntFlag{
+		Name:  "backend.quorum",
+		Usage: "Number of backends that must return the same logs for a block range, 0 or 1 trusts a single backend",
+		Value: 0,
+	}
+
 	FlagCompress = &cli.BoolFlag{
 		Name:  "compress",
 		Usage: "compress",
@@ -84,13 +96,85 @@ var (
 
 	FlagEthLogsStartBlock = &cli.Int64Flag{
 		Name:  "block.start",
-		Usage: string(_mustMarshal(DefaultStartBlocks)),
+		Usage: "Block indexing starts at, entry points deployed later start at their deploy block",
 		Value: 0,
 	}
 
 	FlagEthLogsBlockRange = &cli.Int64Flag{
 		Name:  "block.range",
-		Usage: "eth_getLogs block range",
+		Usage: "eth_getLogs block range, the chain registry suggestion if not set",
 		Value: 1000,
 	}
+
+	FlagReorgDepth = &cli.Int64Flag{
+		Name:  "reorg.depth",
+		Usage: "Number of recent blocks checked for chain reorganizations",
+		Value: DefaultReorgDepth,
+	}
+
+	FlagFinality = &cli.StringFlag{
+		Name:  "finality",
+		Usage: "Indexing head: 'latest', 'safe', 'finalized' or a number of confirmations",
+		Value: FinalityLatest,
+	}
+
+	FlagBackfillWorkers = &cli.IntFlag{
+		Name:  "backfill.workers",
+		Usage: "Number of concurrent eth_getLogs requests used to catch up with the head, 0 disables the backfill",
+		Value: 0,
+	}
+
+	FlagShutdownTimeout = &cli.DurationFlag{
+		Name:  "shutdown.timeout",
+		Usage: "Time given to drain the servers on SIGINT/SIGTERM",
+		Value: DefaultShutdownTimeout,
+	}
+
+	FlagFromBlock = &cli.Int64Flag{
+		Name:  "from",
+		Usage: "First block of the range",
+	}
+
+	FlagToBlock = &cli.Int64Flag{
+		Name:  "to",
+		Usage: "Last block of the range, at least the reorg depth below the head",
+	}
+
+	FlagFormat = &cli.StringFlag{
+		Name:  "format",
+		Usage: "Export format, 'jsonl' or 'csv', a file ending in .csv defaults to csv",
+	}
+
+	FlagOutput = &cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   "Output file, stdout if not set",
+	}
+
+	FlagFromEngine = &cli.StringFlag{
+		Name:     "from-engine",
+		Usage:    "Engine of the store to migrate from ('memory', 'redis', 'pebble' or 'databend')",
+		Required: true,
+	}
+
+	FlagFromDataSource = &cli.StringFlag{
+		Name:  "from-ds",
+		Usage: "Data source of the store to migrate from",
+	}
+
+	FlagToEngine = &cli.StringFlag{
+		Name:     "to-engine",
+		Usage:    "Engine of the store to migrate to ('memory', 'redis', 'pebble' or 'databend')",
+		Required: true,
+	}
+
+	FlagToDataSource = &cli.StringFlag{
+		Name:  "to-ds",
+		Usage: "Data source of the store to migrate to",
+	}
+
+	FlagRepair = &cli.BoolFlag{
+		Name:  "repair",
+		Usage: "Delete the extra user operations, index the missing ones again and record the range as indexed",
+	}
 )
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
@@ -35,8 +36,8 @@ func (s *GrpcServer) Db() database.KVStore {
 	return s.db
 }
 
-func (s *GrpcServer) EntryPoints() []string {
-	return s.cfg.EntryPoints
+func (s *GrpcServer) EntryPoints(chain string) []string {
+	return s.cfg.EntryPointAddresses(chain)
 }
 
 func (s *GrpcServer) Compressed() bool {
@@ -57,6 +58,14 @@ func NewGrpcServer(cfg *Config, db database.KVStore) *GrpcServer {
 func (s *GrpcServer) registerHandlers() {
 	s.handlers["eth_getLogsByUserOperation"] = eth_getLogsByUserOperation
 	s.handlers["eth_getLogs"] = eth_getLogs
+	s.handlers["eth_getUserOperationByHash"] = eth_getUserOperationByHash
+	s.handlers["eth_getUserOperationReceipt"] = eth_getUserOperationReceipt
+	s.handlers["indexer_getUserOperationEvents"] = indexer_getUserOperationEvents
+	s.handlers["eth_getUserOperationsBySender"] = eth_getUserOperationsBySender
+	s.handlers["eth_getUserOperationsByTransaction"] = eth_getUserOperationsByTransaction
+	s.handlers["indexer_getPaymasterOperations"] = indexer_getPaymasterOperations
+	s.handlers["indexer_getPaymasterStats"] = indexer_getPaymasterStats
+	s.handlers["indexer_getBeforeExecutionLogs"] = indexer_getBeforeExecutionLogs
 }
 
 func (s *GrpcServer) loadTLSCredentials() (credentials.TransportCredentials, error) {
@@ -71,7 +80,9 @@ func (s *GrpcServer) loadTLSCredentials() (credentials.TransportCredentials, err
 	return serverCert, nil
 }
 
-func (s *GrpcServer) Run() error {
+// Run serves the api until ctx is done, then drains the in-flight requests for
+// at most the shutdown timeout.
+func (s *GrpcServer) Run(ctx context.Context) error {
 	s.registerHandlers()
 
 	var opts []grpc.ServerOption
@@ -90,6 +101,8 @@ func (s *GrpcServer) Run() error {
 
 	server := grpc.NewServer(
 		grpc.MaxConcurrentStreams(uint32(s.maxConcurrentStreams)),
+		// Stop waits for the running handlers as well, the store is closed after Run
+		grpc.WaitForHandlers(true),
 	)
 
 	proto.RegisterRelayServer(server, s)
@@ -100,8 +113,31 @@ func (s *GrpcServer) Run() error {
 		panic(err)
 	}
 
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+
+		drained := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-time.After(s.cfg.ShutdownTimeout):
+			s.logger.Warn("grpc server shutdown timed out")
+			server.Stop()
+		}
+	}()
+
 	s.logger.Info("grpc server listen: " + s.cfg.GrpcListen)
-	return server.Serve(listen)
+	if err := server.Serve(listen); err != nil {
+		return err
+	}
+	<-stopped
+	s.logger.Info("grpc server stopped")
+	return nil
 }
 
 func (s *GrpcServer) Relay(ctx context.Context, request *proto.Request) (*proto.Response, error) {
@@ -1,26 +1,83 @@
 package indexer
 
-import "golang.org/x/sync/errgroup"
+import (
+	"context"
+	"fmt"
+	"io"
 
-func Run(cfg *Config) error {
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// Run runs the backends, each under a supervisor, and the servers until ctx is
+// done or a server fails, then closes the store once all of them have stopped.
+func Run(ctx context.Context, cfg *Config) error {
 	db := NewDb(cfg.Db.Engin, cfg.Db.Ds)
-	wg := errgroup.Group{}
+	wg, ctx := errgroup.WithContext(ctx)
 
 	if !cfg.Readonly {
 		for _, chain := range cfg.Chains {
-			backend := NewBackend(cfg.Headers, cfg.EntryPoints, chain, db, cfg.Compress)
 			wg.Go(func() error {
-				return backend.Run()
+				return superviseChain(ctx, cfg, chain, db)
 			})
 		}
 	}
 
 	wg.Go(func() error {
-		return NewServer(cfg, db).Run()
+		return NewServer(cfg, db).Run(ctx)
 	})
 	wg.Go(func() error {
-		return NewGrpcServer(cfg, db).Run()
+		return NewGrpcServer(cfg, db).Run(ctx)
+	})
+
+	err := wg.Wait()
+	if closeErr := db.Close(); closeErr != nil {
+		log.Error("error close db", "err", closeErr)
+	}
+	return err
+}
+
+// Backfill (re)indexes [fromBlock, toBlock] of a chain into the configured
+// store and returns, the cursors of a running indexer are left untouched. The
+// entry points default to those configured for the chain.
+func Backfill(ctx context.Context, cfg *Config, chain string, fromBlock, toBlock int64, eps []EntryPointCfg) error {
+	return withBackend(cfg, chain, eps, func(backend *Backend) error {
+		return backend.IndexRange(ctx, fromBlock, toBlock)
 	})
+}
+
+// Verify compares the user operations a chain has stored for [fromBlock,
+// toBlock] with the chain and writes the differences to w, see Backend.Verify.
+func Verify(ctx context.Context, cfg *Config, chain string, fromBlock, toBlock int64, eps []EntryPointCfg, repair bool, w io.Writer) error {
+	return withBackend(cfg, chain, eps, func(backend *Backend) error {
+		return backend.Verify(ctx, fromBlock, toBlock, repair, w)
+	})
+}
+
+// withBackend runs fn with a backend of a configured chain that is not
+// started, on its own connection to the store.
+func withBackend(cfg *Config, chain string, eps []EntryPointCfg, fn func(backend *Backend) error) (err error) {
+	defer recoverError(&err)
+
+	var chainCfg *ChainCfg
+	for i := range cfg.Chains {
+		if cfg.Chains[i].Chain == chain {
+			chainCfg = &cfg.Chains[i]
+		}
+	}
+	if chainCfg == nil {
+		return fmt.Errorf("chain %s is not configured", chain)
+	}
+	if len(eps) > 0 {
+		chainCfg.EntryPoints = eps
+	}
+
+	db := NewDb(cfg.Db.Engin, cfg.Db.Ds)
+	defer func() {
+		if closeErr := db.Close(); closeErr != nil {
+			log.Error("error close db", "err", closeErr)
+		}
+	}()
 
-	return wg.Wait()
+	return fn(NewBackend(cfg.Headers, cfg.EntryPoints, *chainCfg, db, cfg.Compress))
 }
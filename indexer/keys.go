@@ -1,17 +1,109 @@
 package indexer
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 var (
-	dbKeyUserOpPrefix = "op"
+	dbKeyUserOpPrefix = SpaceUserOp
+
+	// _plainKeyPrefixes are the keys stored uncompressed whatever the config,
+	// the cursors and the other bookkeeping of the backends
+	_plainKeyPrefixes = []string{"start-block:", "deploy-block:", "heads:", "reorg-window:", "indexed-ranges:", "backfill-range:"}
 )
 
-func DbKeyStartBlock(chain string) string {
+// isPlainKey reports whether a key is stored uncompressed whatever the config.
+func isPlainKey(key string) bool {
+	for _, prefix := range _plainKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DbKeyStartBlock is the cursor of an entry point on a chain.
+func DbKeyStartBlock(chain, entryPoint string) string {
+	dbKey := fmt.Sprintf("start-block:%s:%s", chain, strings.ToLower(entryPoint))
+	return dbKey
+}
+
+// DbKeyChainStartBlock is the cursor shared by all entry points of a chain
+// before they had their own.
+func DbKeyChainStartBlock(chain string) string {
 	dbKey := fmt.Sprintf("start-block:%s", chain)
 	return dbKey
 }
 
+// DbKeyReorgWindowPrefix is the prefix of the reorg window records of a chain.
+func DbKeyReorgWindowPrefix(chain string) string {
+	dbKey := fmt.Sprintf("reorg-window:%s:", chain)
+	return dbKey
+}
+
+// DbKeyReorgWindow is the reorg window record of a block, ordered by number.
+func DbKeyReorgWindow(chain string, blockNumber int64) string {
+	dbKey := fmt.Sprintf("%s%016x", DbKeyReorgWindowPrefix(chain), blockNumber)
+	return dbKey
+}
+
+func DbKeyHeads(chain string) string {
+	dbKey := fmt.Sprintf("heads:%s", chain)
+	return dbKey
+}
+
 func DbKeyUserOp(chain, op string) string {
 	dbKey := fmt.Sprintf("%s:%s:%s", chain, dbKeyUserOpPrefix, op)
 	return dbKey
 }
+
+func DbKeyEvent(chain, space, key string) string {
+	dbKey := fmt.Sprintf("%s:%s:%s", chain, space, key)
+	return dbKey
+}
+
+func DbKeyEventLogPrefix(chain, space, key string) string {
+	dbKey := fmt.Sprintf("%s:%s:%s:", chain, space, key)
+	return dbKey
+}
+
+func DbKeyEventLog(chain, space, key string, blockNumber uint64, logIndex uint) string {
+	dbKey := fmt.Sprintf("%s%016x:%08x", DbKeyEventLogPrefix(chain, space, key), blockNumber, logIndex)
+	return dbKey
+}
+
+func DbKeyPaymasterStats(chain, paymaster string) string {
+	dbKey := fmt.Sprintf("%s:%s:%s", chain, SpacePaymasterStats, paymaster)
+	return dbKey
+}
+
+func DbKeyPaymasterDayStats(chain, paymaster, day string) string {
+	dbKey := fmt.Sprintf("%s:%s", DbKeyPaymasterStats(chain, paymaster), day)
+	return dbKey
+}
+
+func DbKeyDeployBlock(chain, entryPoint string) string {
+	dbKey := fmt.Sprintf("deploy-block:%s:%s", chain, strings.ToLower(entryPoint))
+	return dbKey
+}
+
+// DbKeyBackfillRange is the progress of a range backfill, one per chain, range
+// and set of entry points so that a rerun of the same job resumes it.
+func DbKeyBackfillRange(chain string, fromBlock, toBlock int64, entryPoints []string) string {
+	eps := make([]string, len(entryPoints))
+	for i, ep := range entryPoints {
+		eps[i] = strings.ToLower(ep)
+	}
+	sort.Strings(eps)
+	dbKey := fmt.Sprintf("backfill-range:%s:%v-%v:%s", chain, fromBlock, toBlock, strings.Join(eps, ","))
+	return dbKey
+}
+
+// DbKeyIndexedRanges is the ledger of the block ranges an entry point of a
+// chain has been indexed over.
+func DbKeyIndexedRanges(chain, entryPoint string) string {
+	dbKey := fmt.Sprintf("indexed-ranges:%s:%s", chain, strings.ToLower(entryPoint))
+	return dbKey
+}
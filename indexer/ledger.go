@@ -0,0 +1,152 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// BlockRange is an inclusive range of blocks.
+type BlockRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+func (r BlockRange) String() string {
+	return fmt.Sprintf("[%v,%v]", r.From, r.To)
+}
+
+// indexedRanges is the ledger of an entry point: the disjoint block ranges it
+// has been indexed over, ordered by block number.
+type indexedRanges []BlockRange
+
+// add merges [from, to] into the ranges and returns the gap it leaves below
+// itself, if any.
+func (r indexedRanges) add(from, to int64) (indexedRanges, *BlockRange) {
+	merged := BlockRange{From: from, To: to}
+	var result indexedRanges
+	var gap *BlockRange
+	for _, existing := range r {
+		if existing.To+1 < merged.From || existing.From > merged.To+1 {
+			result = append(result, existing)
+			continue
+		}
+		merged.From = min(merged.From, existing.From)
+		merged.To = max(merged.To, existing.To)
+	}
+	result = append(result, merged)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].From < result[j].From
+	})
+
+	// only a range above all others is a step forward that may skip blocks
+	if n := len(result); n > 1 && result[n-1] == merged {
+		gap = &BlockRange{From: result[n-2].To + 1, To: merged.From - 1}
+	}
+	return result, gap
+}
+
+// truncate drops everything above block.
+func (r indexedRanges) truncate(block int64) indexedRanges {
+	var result indexedRanges
+	for _, existing := range r {
+		if existing.From > block {
+			break
+		}
+		existing.To = min(existing.To, block)
+		result = append(result, existing)
+	}
+	return result
+}
+
+// gaps returns the parts of [from, to] not covered by the ranges.
+func (r indexedRanges) gaps(from, to int64) []BlockRange {
+	var gaps []BlockRange
+	next := from
+	for _, existing := range r {
+		if existing.To < next {
+			continue
+		}
+		if existing.From > to {
+			break
+		}
+		if existing.From > next {
+			gaps = append(gaps, BlockRange{From: next, To: existing.From - 1})
+		}
+		next = existing.To + 1
+	}
+	if next <= to {
+		gaps = append(gaps, BlockRange{From: next, To: to})
+	}
+	return gaps
+}
+
+type rangeReader interface {
+	Get(key string, compressed bool) ([]byte, error)
+}
+
+func loadRanges(db rangeReader, key string) (indexedRanges, error) {
+	val, err := db.Get(key, false)
+	if err != nil {
+		return nil, fmt.Errorf("error get db key %s: %w", key, err)
+	}
+	var ranges indexedRanges
+	if len(val) > 0 {
+		if err := json.Unmarshal(val, &ranges); err != nil {
+			return nil, fmt.Errorf("error decode db key %s: %w", key, err)
+		}
+	}
+	return ranges, nil
+}
+
+// IndexedRanges returns the block ranges an entry point of a chain has been
+// indexed over.
+func IndexedRanges(db database.KVStore, chain, entryPoint string) ([]BlockRange, error) {
+	return loadRanges(db, DbKeyIndexedRanges(chain, entryPoint))
+}
+
+// recordRange adds [fromBlock, toBlock] to the ledgers of the entry points in
+// batch, the caller holds writeLock. A range leaving a gap below it is logged,
+// unless a backfill is still filling it.
+func (b *Backend) recordRange(batch database.Batch, addresses []common.Address, fromBlock, toBlock int64) error {
+	warn := !b.ranged && !b.isBackfilling()
+	for _, address := range addresses {
+		key := DbKeyIndexedRanges(b.chain, address.Hex())
+		ranges, err := loadRanges(batch, key)
+		if err != nil {
+			return err
+		}
+		ranges, gap := ranges.add(fromBlock, toBlock)
+		if gap != nil && warn {
+			b.logger.Warn(fmt.Sprintf("indexed range [%v,%v] leaves the gap %s", fromBlock, toBlock, gap), "entrypoint", address.Hex(), "chain", b.chain)
+		}
+		data, _ := json.Marshal(ranges)
+		if err := batch.Put(key, data, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// truncateRanges drops the blocks above block from the ledgers of all entry
+// points in batch, the caller holds writeLock.
+func (b *Backend) truncateRanges(batch database.Batch, block int64) error {
+	for _, address := range b.entryPoints {
+		key := DbKeyIndexedRanges(b.chain, address.Hex())
+		ranges, err := loadRanges(batch, key)
+		if err != nil {
+			return err
+		}
+		if len(ranges) == 0 || ranges[len(ranges)-1].To <= block {
+			continue
+		}
+		data, _ := json.Marshal(ranges.truncate(block))
+		if err := batch.Put(key, data, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package indexer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIndexedRanges(t *testing.T) {
+	var ranges indexedRanges
+	ranges, gap := ranges.add(100, 199)
+	if gap != nil {
+		t.Fatalf("first range leaves gap %s", gap)
+	}
+	// rounds overlap by one block
+	ranges, gap = ranges.add(199, 299)
+	if gap != nil {
+		t.Fatalf("contiguous range leaves gap %s", gap)
+	}
+	ranges, gap = ranges.add(400, 499)
+	if gap == nil || *gap != (BlockRange{From: 300, To: 399}) {
+		t.Fatalf("skipping range leaves gap %v, want [300,399]", gap)
+	}
+	ranges, gap = ranges.add(0, 49)
+	if gap != nil {
+		t.Fatalf("range below the others leaves gap %s", gap)
+	}
+	if got := fmt.Sprint(ranges); got != "[[0,49] [100,299] [400,499]]" {
+		t.Fatalf("ranges %s", got)
+	}
+	if got := fmt.Sprint(ranges.gaps(0, 599)); got != "[[50,99] [300,399] [500,599]]" {
+		t.Fatalf("gaps %s", got)
+	}
+
+	ranges, _ = ranges.add(250, 450)
+	if got := fmt.Sprint(ranges); got != "[[0,49] [100,499]]" {
+		t.Fatalf("ranges after filling the gap %s", got)
+	}
+	if got := fmt.Sprint(ranges.truncate(120)); got != "[[0,49] [100,120]]" {
+		t.Fatalf("truncated ranges %s", got)
+	}
+	if got := fmt.Sprint(ranges.truncate(60)); got != "[[0,49]]" {
+		t.Fatalf("truncated ranges %s", got)
+	}
+}
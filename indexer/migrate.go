@@ -0,0 +1,123 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
+)
+
+const (
+	_migrateBatch         = 1000
+	_maxMigrateMismatches = 10
+)
+
+// migratePass is a walk over the keys of a store that are stored with the same
+// compression.
+type migratePass struct {
+	prefix     string
+	compressed bool
+}
+
+// migratePasses returns the walks covering all keys of a store, the plain keys
+// are read and written uncompressed and all others as configured.
+func migratePasses(compress bool) []migratePass {
+	passes := []migratePass{{prefix: "", compressed: compress}}
+	for _, prefix := range _plainKeyPrefixes {
+		passes = append(passes, migratePass{prefix: prefix, compressed: false})
+	}
+	return passes
+}
+
+// walk calls fn for the keys of the pass.
+func (p migratePass) walk(db database.KVStore, fn func(key string, value []byte) error) error {
+	var walkErr error
+	err := db.Iterate(p.prefix, "", p.compressed, func(key string, value []byte) bool {
+		if p.prefix == "" && isPlainKey(key) {
+			return true
+		}
+		walkErr = fn(key, value)
+		return walkErr == nil
+	})
+	if err == nil {
+		err = walkErr
+	}
+	return err
+}
+
+// Migrate copies all keys of src, the indexed logs and records as well as the
+// cursors, to dst in batches and then verifies that dst holds the same values.
+// Keys only in dst are left alone. It returns the number of copied keys.
+func Migrate(ctx context.Context, src, dst database.KVStore, compress bool) (int, error) {
+	logger := log.Module("migrate")
+
+	var count int
+	for _, pass := range migratePasses(compress) {
+		batch := dst.NewBatch()
+		write := func() error {
+			if batch.Len() == 0 {
+				return nil
+			}
+			if err := batch.Write(); err != nil {
+				return fmt.Errorf("error write batch: %w", err)
+			}
+			logger.Info("copied keys", "count", count)
+			return nil
+		}
+
+		err := pass.walk(src, func(key string, value []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := batch.Put(key, value, pass.compressed); err != nil {
+				return err
+			}
+			count++
+			if batch.Len() >= _migrateBatch {
+				return write()
+			}
+			return nil
+		})
+		if err == nil {
+			err = write()
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+	logger.Info("copy done, verifying", "keys", count)
+
+	var checked, mismatches int
+	for _, pass := range migratePasses(compress) {
+		err := pass.walk(src, func(key string, value []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			copied, err := dst.Get(key, pass.compressed)
+			if err != nil {
+				return fmt.Errorf("error get db key %s: %w", key, err)
+			}
+			if !bytes.Equal(copied, value) {
+				if mismatches < _maxMigrateMismatches {
+					logger.Error("copied value differs", "key", key, "size", len(value), "copied", len(copied))
+				}
+				mismatches++
+			}
+			checked++
+			if checked%(_migrateBatch*10) == 0 {
+				logger.Info("verified keys", "count", checked)
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+	if mismatches > 0 {
+		return count, fmt.Errorf("%v of %v keys differ after the copy, was the source written meanwhile?", mismatches, checked)
+	}
+	logger.Info("verify done", "keys", checked)
+	return count, nil
+}
@@ -0,0 +1,39 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+)
+
+func TestMigrate(t *testing.T) {
+	src := memorydb.New()
+	src.Put(DbKeyStartBlock("test", "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"), []byte("150"), false)
+	src.Put(DbKeyIndexedRanges("test", "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"), []byte(`[{"from":100,"to":150}]`), false)
+	for i := 0; i < _migrateBatch+1; i++ {
+		src.Put(DbKeyUserOp("test", fmt.Sprintf("0x%04x", i)), []byte("log"), true)
+	}
+
+	dst := memorydb.New()
+	dst.Put("other", []byte("kept"), false)
+	count, err := Migrate(context.Background(), src, dst, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dumpStore(t, src)
+	if count != len(want) {
+		t.Fatalf("copied %v keys, want %v", count, len(want))
+	}
+	want["other"] = "kept"
+	got := dumpStore(t, dst)
+	if len(got) != len(want) {
+		t.Fatalf("migrated store has %v keys, want %v", len(got), len(want))
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("migrated %s = %s, want %s", key, got[key], value)
+		}
+	}
+}
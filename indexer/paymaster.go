@@ -0,0 +1,282 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/golang/snappy"
+)
+
+const (
+	// SpacePaymaster lists the user operation hashes sponsored by each
+	// paymaster, ordered by block number and log index.
+	SpacePaymaster = "paymaster"
+	// SpacePaymasterStats holds the running totals of each paymaster, overall
+	// and per UTC day.
+	SpacePaymasterStats = "paymaster-stats"
+	// SpacePaymasterChange holds the paymaster total changes deferred by
+	// backfills and repairs, by user operation hash.
+	SpacePaymasterChange = "paymaster-change"
+
+	paymasterDayLayout = "2006-01-02"
+
+	_maxPaymasterChanges = 1000
+)
+
+// PaymasterStats are the totals of the user operations sponsored by a
+// paymaster.
+type PaymasterStats struct {
+	Day           string       `json:"day,omitempty"`
+	Count         uint64       `json:"count"`
+	Failed        uint64       `json:"failed"`
+	ActualGasCost *hexutil.Big `json:"actualGasCost"`
+}
+
+func (s *PaymasterStats) apply(event *UserOperationEvent, sign int) {
+	cost := (*big.Int)(s.ActualGasCost)
+	if cost == nil {
+		cost = new(big.Int)
+	}
+	if event.ActualGasCost != nil {
+		delta := (*big.Int)(event.ActualGasCost)
+		if sign < 0 {
+			cost = new(big.Int).Sub(cost, delta)
+		} else {
+			cost = new(big.Int).Add(cost, delta)
+		}
+	}
+	if cost.Sign() < 0 {
+		cost = new(big.Int)
+	}
+	s.ActualGasCost = (*hexutil.Big)(cost)
+
+	switch {
+	case sign > 0:
+		s.Count++
+		if !event.Success {
+			s.Failed++
+		}
+	case s.Count > 0:
+		s.Count--
+		if !event.Success && s.Failed > 0 {
+			s.Failed--
+		}
+	}
+}
+
+func paymasterDay(timestamp uint64) string {
+	return time.Unix(int64(timestamp), 0).UTC().Format(paymasterDayLayout)
+}
+
+func isSponsored(event *UserOperationEvent) bool {
+	return event.Paymaster != (common.Address{})
+}
+
+func (b *Backend) get(batch database.Batch, key string) ([]byte, error) {
+	data, err := batch.Get(key, b.compress)
+	if err != nil || data == nil || !b.compress {
+		return data, err
+	}
+	return snappy.Decode(nil, data)
+}
+
+func (b *Backend) loadUserOpEvent(batch database.Batch, key string) (*UserOperationEvent, error) {
+	data, err := b.get(batch, key)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	event := &UserOperationEvent{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, fmt.Errorf("error decode db key %s: %w", key, err)
+	}
+	return event, nil
+}
+
+func (b *Backend) updatePaymasterStats(batch database.Batch, key string, event *UserOperationEvent, sign int) error {
+	stats := &PaymasterStats{}
+	data, err := b.get(batch, key)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, stats); err != nil {
+			return fmt.Errorf("error decode db key %s: %w", key, err)
+		}
+	}
+	stats.apply(event, sign)
+	data, _ = json.Marshal(stats)
+	return b.put(batch, key, data)
+}
+
+// accountPaymaster adds (sign > 0) or removes (sign < 0) a sponsored user
+// operation to the totals of its paymaster.
+func (b *Backend) accountPaymaster(batch database.Batch, event *UserOperationEvent, sign int) error {
+	if !isSponsored(event) {
+		return nil
+	}
+	paymaster := strings.ToLower(event.Paymaster.Hex())
+	if err := b.updatePaymasterStats(batch, DbKeyPaymasterStats(b.chain, paymaster), event, sign); err != nil {
+		return err
+	}
+	day := DbKeyPaymasterDayStats(b.chain, paymaster, paymasterDay(uint64(event.BlockTimestamp)))
+	return b.updatePaymasterStats(batch, day, event, sign)
+}
+
+// paymasterChange is a change of the paymaster totals: the events of a user
+// operation to take back from them and to add to them. Backfills and repairs
+// may run in another process than the indexer following the head, so instead
+// of updating the totals they record the change for that indexer to apply,
+// which keeps a single writer of the totals. Changes to the same user
+// operation are merged.
+type paymasterChange struct {
+	Sub []*UserOperationEvent `json:"sub,omitempty"`
+	Add []*UserOperationEvent `json:"add,omitempty"`
+}
+
+func sameUserOpEvent(a, b *UserOperationEvent) bool {
+	return a.BlockHash == b.BlockHash && a.LogIndex == b.LogIndex
+}
+
+// cancel removes the event from events, it reports whether it was there.
+func cancel(events *[]*UserOperationEvent, event *UserOperationEvent) bool {
+	for i, e := range *events {
+		if sameUserOpEvent(e, event) {
+			*events = append((*events)[:i], (*events)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// merge adds replacing existing by event, either may be nil.
+func (c *paymasterChange) merge(existing, event *UserOperationEvent) {
+	if existing != nil && !cancel(&c.Add, existing) {
+		c.Sub = append(c.Sub, existing)
+	}
+	if event != nil && !cancel(&c.Sub, event) {
+		c.Add = append(c.Add, event)
+	}
+}
+
+// accountChange updates the paymaster totals for a user operation stored as
+// event instead of existing, either may be nil, or defers it with deferStats.
+func (b *Backend) accountChange(batch database.Batch, opHash string, existing, event *UserOperationEvent) error {
+	if !b.deferStats {
+		if existing != nil {
+			if err := b.accountPaymaster(batch, existing, -1); err != nil {
+				return err
+			}
+		}
+		if event != nil {
+			return b.accountPaymaster(batch, event, 1)
+		}
+		return nil
+	}
+
+	key := DbKeyEvent(b.chain, SpacePaymasterChange, opHash)
+	change := &paymasterChange{}
+	data, err := b.get(batch, key)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, change); err != nil {
+			return fmt.Errorf("error decode db key %s: %w", key, err)
+		}
+	}
+	change.merge(existing, event)
+	if len(change.Sub) == 0 && len(change.Add) == 0 {
+		return batch.Delete(key)
+	}
+	data, _ = json.Marshal(change)
+	return b.put(batch, key, data)
+}
+
+// applyPaymasterChanges applies up to _maxPaymasterChanges deferred changes
+// to the paymaster totals. The caller holds writeLock.
+func (b *Backend) applyPaymasterChanges(batch database.Batch) error {
+	var keys []string
+	var changes []*paymasterChange
+	var decodeErr error
+	err := b.db.Iterate(DbKeyEvent(b.chain, SpacePaymasterChange, ""), "", b.compress, func(key string, data []byte) bool {
+		if b.compress {
+			if data, decodeErr = snappy.Decode(nil, data); decodeErr != nil {
+				decodeErr = fmt.Errorf("error decode db key %s: %w", key, decodeErr)
+				return false
+			}
+		}
+		change := &paymasterChange{}
+		if decodeErr = json.Unmarshal(data, change); decodeErr != nil {
+			decodeErr = fmt.Errorf("error decode db key %s: %w", key, decodeErr)
+			return false
+		}
+		keys = append(keys, key)
+		changes = append(changes, change)
+		return len(keys) < _maxPaymasterChanges
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, change := range changes {
+		for _, event := range change.Sub {
+			if err := b.accountPaymaster(batch, event, -1); err != nil {
+				return err
+			}
+		}
+		for _, event := range change.Add {
+			if err := b.accountPaymaster(batch, event, 1); err != nil {
+				return err
+			}
+		}
+		if err := batch.Delete(keys[i]); err != nil {
+			return err
+		}
+	}
+	if len(changes) > 0 {
+		b.logger.Info("applied deferred paymaster changes", "count", len(changes), "chain", b.chain)
+	}
+	return nil
+}
+
+// accountUserOpEvent updates the paymaster totals for a UserOperationEvent
+// about to be stored for opHash. Storing the same log again is a no-op, while
+// a log replaced by a reorg takes back the totals of the previous one. The
+// caller holds writeLock.
+func (b *Backend) accountUserOpEvent(batch database.Batch, opHash string, event *UserOperationEvent) error {
+	existing, err := b.loadUserOpEvent(batch, DbKeyEvent(b.chain, SpaceUserOpEvent, opHash))
+	if err != nil {
+		return err
+	}
+	if existing != nil && sameUserOpEvent(existing, event) {
+		return nil
+	}
+	return b.accountChange(batch, opHash, existing, event)
+}
+
+// deleteKey deletes an indexed key, taking a deleted UserOperationEvent back
+// from the paymaster totals. The caller holds writeLock.
+func (b *Backend) deleteKey(batch database.Batch, key string) error {
+	prefix := DbKeyEvent(b.chain, SpaceUserOpEvent, "")
+	if strings.HasPrefix(key, prefix) {
+		existing, err := b.loadUserOpEvent(batch, key)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			if err := b.accountChange(batch, strings.TrimPrefix(key, prefix), existing, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Delete(key)
+}
@@ -0,0 +1,143 @@
+package indexer
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var _testPaymaster = common.HexToAddress("0x03")
+
+func userOpLog(t *testing.T, opHash common.Hash, blockNumber uint64, blockHash common.Hash, index uint, success bool) types.Log {
+	event := EntryPointAbi.Events["UserOperationEvent"]
+	data, err := event.Inputs.NonIndexed().Pack(big.NewInt(1), success, big.NewInt(1000), big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return types.Log{
+		Address:     common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"),
+		Topics:      []common.Hash{event.ID, opHash, common.HexToHash("0x02"), common.BytesToHash(_testPaymaster.Bytes())},
+		Data:        data,
+		BlockNumber: blockNumber,
+		BlockHash:   blockHash,
+		TxHash:      common.HexToHash("0x0b"),
+		Index:       index,
+	}
+}
+
+func testRangeLogs(ethlogs ...types.Log) *rangeLogs {
+	return &rangeLogs{
+		logs:         ethlogs,
+		timestamps:   map[common.Hash]uint64{},
+		transactions: map[common.Hash]*bundleTx{},
+		userOps:      map[common.Hash][]*entrypoint.UserOperation{},
+		receipts:     map[common.Hash]json.RawMessage{},
+	}
+}
+
+func saveTestLogs(t *testing.T, b *Backend, ethlogs ...types.Log) {
+	batch := b.db.NewBatch()
+	if err := b.saveLogs(batch, testRangeLogs(ethlogs...), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func paymasterStats(t *testing.T, db database.KVStore, chain string) PaymasterStats {
+	var stats PaymasterStats
+	data, err := db.Get(DbKeyPaymasterStats(chain, strings.ToLower(_testPaymaster.Hex())), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return stats
+}
+
+func deferredChanges(t *testing.T, db database.KVStore) int {
+	var n int
+	err := db.Iterate(DbKeyEvent("test", SpacePaymasterChange, ""), "", false, func(string, []byte) bool {
+		n++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPaymasterStats(t *testing.T) {
+	db := memorydb.New()
+	b := newStoreBackend("test", db, false)
+
+	op := userOpLog(t, common.HexToHash("0x01"), 100, common.HexToHash("0xa1"), 0, true)
+	failed := userOpLog(t, common.HexToHash("0x02"), 100, common.HexToHash("0xa1"), 1, false)
+	saveTestLogs(t, b, op, failed)
+	// storing the same logs again is not counted twice
+	saveTestLogs(t, b, op, failed)
+	if stats := paymasterStats(t, db, "test"); stats.Count != 2 || stats.Failed != 1 || stats.ActualGasCost.ToInt().Int64() != 2000 {
+		t.Fatalf("stats %+v, want 2 operations, 1 failed", stats)
+	}
+
+	// a reorg moves the operation to another block
+	removed := op
+	removed.Removed = true
+	moved := userOpLog(t, common.HexToHash("0x01"), 101, common.HexToHash("0xb1"), 0, false)
+	saveTestLogs(t, b, removed, moved)
+	if stats := paymasterStats(t, db, "test"); stats.Count != 2 || stats.Failed != 2 {
+		t.Fatalf("stats after reorg %+v, want 2 operations, 2 failed", stats)
+	}
+}
+
+func TestDeferredPaymasterStats(t *testing.T) {
+	db := memorydb.New()
+	live := newStoreBackend("test", db, false)
+	op := userOpLog(t, common.HexToHash("0x01"), 100, common.HexToHash("0xa1"), 0, true)
+	saveTestLogs(t, live, op)
+
+	backfill := newStoreBackend("test", db, false)
+	backfill.deferStats = true
+	// indexing the same operation again changes nothing
+	saveTestLogs(t, backfill, op)
+	if n := deferredChanges(t, db); n != 0 {
+		t.Fatalf("%v deferred changes for an unchanged operation", n)
+	}
+
+	added := userOpLog(t, common.HexToHash("0x02"), 90, common.HexToHash("0xa2"), 0, true)
+	removed := op
+	removed.Removed = true
+	saveTestLogs(t, backfill, added, removed)
+	if stats := paymasterStats(t, db, "test"); stats.Count != 1 {
+		t.Fatalf("stats %+v changed before the deferred changes are applied", stats)
+	}
+
+	// the live indexer replaces the removed operation meanwhile, then applies
+	// the deferred changes
+	moved := userOpLog(t, common.HexToHash("0x01"), 101, common.HexToHash("0xb1"), 0, true)
+	saveTestLogs(t, live, moved)
+	batch := db.NewBatch()
+	if err := live.applyPaymasterChanges(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := paymasterStats(t, db, "test"); stats.Count != 2 {
+		t.Fatalf("stats %+v, want 2 operations", stats)
+	}
+	if n := deferredChanges(t, db); n != 0 {
+		t.Fatalf("%v deferred changes left", n)
+	}
+}
@@ -0,0 +1,278 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+)
+
+const (
+	BalanceScore    = "score"
+	BalanceWeighted = "weighted"
+
+	// circuitFailures consecutive failures open the circuit of a backend
+	circuitFailures    = 3
+	circuitBackoff     = 5 * time.Second
+	circuitMaxBackoff  = 5 * time.Minute
+	_poolEwmaWeight    = 0.2
+	_poolLagPenaltyMs  = 100.0
+	_poolErrorPenalty  = 10.0
+	_poolMinLatencyMs  = 1.0
+	_poolInitLatencyMs = 100.0
+)
+
+var errNoAvailableBackend = errors.New("no available backend")
+
+// BackendStatus is the health of a backend as exposed in /status.
+type BackendStatus struct {
+	Url         string     `json:"url"`
+	Head        int64      `json:"head"`
+	Lag         int64      `json:"lag"`
+	LatencyMs   float64    `json:"latency_ms"`
+	ErrorRate   float64    `json:"error_rate"`
+	Score       float64    `json:"score"`
+	Failures    int        `json:"failures"`
+	CircuitOpen bool       `json:"circuit_open"`
+	OpenUntil   *time.Time `json:"open_until,omitempty"`
+}
+
+type poolClient struct {
+	cli       *web3.Web3
+	latency   float64
+	errorRate float64
+	head      int64
+	failures  int
+	openUntil time.Time
+	weight    float64
+}
+
+// clientPool tracks the latency, error rate and head lag of the backends of a
+// chain and picks one for each request. Backends failing repeatedly are put
+// behind a circuit breaker and retried after an exponential backoff.
+type clientPool struct {
+	lock    sync.Mutex
+	clients []*poolClient
+	balance string
+	head    int64
+}
+
+func newClientPool(clients []*web3.Web3, balance string) *clientPool {
+	pool := &clientPool{balance: balance}
+	for _, cli := range clients {
+		pool.clients = append(pool.clients, &poolClient{cli: cli, latency: _poolInitLatencyMs})
+	}
+	return pool
+}
+
+func ParseBalance(balance string) (string, error) {
+	switch balance {
+	case "", BalanceScore:
+		return BalanceScore, nil
+	case BalanceWeighted:
+		return BalanceWeighted, nil
+	}
+	return "", fmt.Errorf("invalid balance '%s', allowed 'score' or 'weighted'", balance)
+}
+
+// score of a backend, lower is better.
+func (p *clientPool) score(c *poolClient) float64 {
+	lag := float64(p.head - c.head)
+	if lag < 0 {
+		lag = 0
+	}
+	return math.Max(c.latency, _poolMinLatencyMs)*(1+_poolErrorPenalty*c.errorRate) + lag*_poolLagPenaltyMs
+}
+
+func (p *clientPool) find(cli *web3.Web3) *poolClient {
+	for _, c := range p.clients {
+		if c.cli == cli {
+			return c
+		}
+	}
+	return nil
+}
+
+// Report records the outcome of a request sent to a backend.
+func (p *clientPool) Report(cli *web3.Web3, latency time.Duration, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	c := p.find(cli)
+	if c == nil {
+		return
+	}
+
+	if err != nil {
+		c.errorRate = c.errorRate*(1-_poolEwmaWeight) + _poolEwmaWeight
+		c.failures++
+		if c.failures >= circuitFailures {
+			backoff := circuitBackoff << (c.failures - circuitFailures)
+			if backoff > circuitMaxBackoff || backoff <= 0 {
+				backoff = circuitMaxBackoff
+			}
+			c.openUntil = time.Now().Add(backoff)
+		}
+		return
+	}
+
+	c.errorRate = c.errorRate * (1 - _poolEwmaWeight)
+	c.latency = c.latency*(1-_poolEwmaWeight) + float64(latency.Milliseconds())*_poolEwmaWeight
+	c.failures = 0
+	c.openUntil = time.Time{}
+}
+
+func (p *clientPool) available(now time.Time, minHead int64) []*poolClient {
+	var clients []*poolClient
+	for _, c := range p.clients {
+		if now.Before(c.openUntil) || c.head < minHead {
+			continue
+		}
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// Pick returns the backend to send the next request to, out of the backends
+// whose circuit is closed and which have reached minHead.
+func (p *clientPool) Pick(minHead int64) (*web3.Web3, error) {
+	if p.balance == BalanceWeighted {
+		return p.Next(minHead)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var best *poolClient
+	for _, c := range p.available(time.Now(), minHead) {
+		if best == nil || p.score(c) < p.score(best) {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil, errNoAvailableBackend
+	}
+	return best.cli, nil
+}
+
+// Available returns the backends whose circuit is closed and which have
+// reached minHead, best scored first.
+func (p *clientPool) Available(minHead int64) []*web3.Web3 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	clients := p.available(time.Now(), minHead)
+	sort.Slice(clients, func(i, j int) bool {
+		return p.score(clients[i]) < p.score(clients[j])
+	})
+
+	var result []*web3.Web3
+	for _, c := range clients {
+		result = append(result, c.cli)
+	}
+	return result
+}
+
+// Next picks the backends in a smooth weighted round-robin, weighted by the
+// inverse of their scores, to spread the load over all healthy backends.
+func (p *clientPool) Next(minHead int64) (*web3.Web3, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var best *poolClient
+	var total float64
+	for _, c := range p.available(time.Now(), minHead) {
+		weight := 1 / p.score(c)
+		c.weight += weight
+		total += weight
+		if best == nil || c.weight > best.weight {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil, errNoAvailableBackend
+	}
+	best.weight -= total
+	return best.cli, nil
+}
+
+// Heads refreshes the head of every backend whose circuit is closed and
+// returns the highest one.
+func (p *clientPool) Heads() (uint64, error) {
+	p.lock.Lock()
+	clients := p.available(time.Now(), 0)
+	p.lock.Unlock()
+
+	heads := make([]int64, len(clients))
+	errs := make([]error, len(clients))
+	wg := sync.WaitGroup{}
+	for idx, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancelFunc := context.WithTimeout(context.Background(), _httpTimeout)
+			defer cancelFunc()
+
+			startTime := time.Now()
+			blockNumber, err := c.cli.Cli().BlockNumber(ctx)
+			p.Report(c.cli, time.Since(startTime), err)
+			heads[idx], errs[idx] = int64(blockNumber), err
+		}()
+	}
+	wg.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var err error = errNoAvailableBackend
+	for idx, c := range clients {
+		if errs[idx] != nil {
+			err = errs[idx]
+			continue
+		}
+		c.head = heads[idx]
+	}
+
+	var head int64
+	for _, c := range p.clients {
+		if c.head > head && !time.Now().Before(c.openUntil) {
+			head = c.head
+		}
+	}
+	if head == 0 {
+		return 0, err
+	}
+	p.head = head
+	return uint64(head), nil
+}
+
+func (p *clientPool) Status() []BackendStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	var stats []BackendStatus
+	for _, c := range p.clients {
+		stat := BackendStatus{
+			Url:         c.cli.Url(),
+			Head:        c.head,
+			Lag:         p.head - c.head,
+			LatencyMs:   math.Round(c.latency*100) / 100,
+			ErrorRate:   math.Round(c.errorRate*1000) / 1000,
+			Score:       math.Round(p.score(c)*100) / 100,
+			Failures:    c.failures,
+			CircuitOpen: now.Before(c.openUntil),
+		}
+		if stat.CircuitOpen {
+			openUntil := c.openUntil
+			stat.OpenUntil = &openUntil
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
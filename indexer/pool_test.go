@@ -0,0 +1,85 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// serveHead answers eth_blockNumber with head, or an error while down is set.
+func (n *stubNode) serveHead(head uint64, down *atomic.Bool) {
+	n.handle("eth_blockNumber", func([]json.RawMessage) (any, error) {
+		if down != nil && down.Load() {
+			return nil, errors.New("backend down")
+		}
+		return hexutil.Uint64(head), nil
+	})
+}
+
+func TestClientPoolCircuitBreaker(t *testing.T) {
+	healthy, healthyCli := newStubNode(t)
+	healthy.serveHead(100, nil)
+	flaky, flakyCli := newStubNode(t)
+	var down atomic.Bool
+	flaky.serveHead(100, &down)
+	pool := newClientPool([]*web3.Web3{healthyCli, flakyCli}, BalanceScore)
+
+	if head, err := pool.Heads(); err != nil || head != 100 {
+		t.Fatalf("heads = %v, %v, want 100", head, err)
+	}
+	if clients := pool.Available(100); len(clients) != 2 {
+		t.Fatalf("%v backends available, want 2", len(clients))
+	}
+
+	// the circuit opens after circuitFailures consecutive failures
+	down.Store(true)
+	for i := 1; i <= circuitFailures; i++ {
+		if _, err := pool.Heads(); err != nil {
+			t.Fatalf("heads with one backend down: %v", err)
+		}
+		if open := pool.Status()[1].CircuitOpen; open != (i == circuitFailures) {
+			t.Fatalf("circuit open %v after %v failures", open, i)
+		}
+	}
+	if clients := pool.Available(0); len(clients) != 1 || clients[0] != healthyCli {
+		t.Fatalf("available backends %v, want the healthy one only", len(clients))
+	}
+	for i := 0; i < 10; i++ {
+		if cli, err := pool.Pick(0); err != nil || cli != healthyCli {
+			t.Fatalf("picked %v, %v, want the healthy backend", cli, err)
+		}
+	}
+	calls := flaky.count("eth_blockNumber")
+	pool.Heads()
+	if flaky.count("eth_blockNumber") != calls {
+		t.Fatal("backend with an open circuit was asked for its head")
+	}
+
+	// the backoff doubles with every failure after the circuit opened
+	pool.Report(flakyCli, 0, errors.New("backend down"))
+	if until := pool.Status()[1].OpenUntil; until == nil || time.Until(*until) < circuitBackoff {
+		t.Fatalf("circuit open until %v, want a doubled backoff", until)
+	}
+
+	// once the backoff elapsed the backend is tried again and a success
+	// closes the circuit
+	down.Store(false)
+	pool.lock.Lock()
+	pool.find(flakyCli).openUntil = time.Now().Add(-time.Second)
+	pool.lock.Unlock()
+	if _, err := pool.Heads(); err != nil {
+		t.Fatal(err)
+	}
+	status := pool.Status()[1]
+	if status.CircuitOpen || status.Failures != 0 {
+		t.Fatalf("backend status %+v after recovery", status)
+	}
+	if clients := pool.Available(100); len(clients) != 2 {
+		t.Fatalf("%v backends available after recovery, want 2", len(clients))
+	}
+}
@@ -0,0 +1,120 @@
+package indexer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+type quorumResult struct {
+	cli     *web3.Web3
+	logs    []types.Log
+	hash    common.Hash
+	err     error
+	fetched bool
+}
+
+// logsHash fingerprints a log set by the block hash, tx hash, log index,
+// topics and data of each log, regardless of the order the backend returned
+// them in. Backends on different forks of a block don't agree.
+func logsHash(ethlogs []types.Log) common.Hash {
+	sorted := append([]types.Log(nil), ethlogs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].BlockNumber != sorted[j].BlockNumber {
+			return sorted[i].BlockNumber < sorted[j].BlockNumber
+		}
+		return sorted[i].Index < sorted[j].Index
+	})
+
+	hasher := crypto.NewKeccakState()
+	for _, ethlog := range sorted {
+		hasher.Write(ethlog.BlockHash.Bytes())
+		hasher.Write(ethlog.TxHash.Bytes())
+		hasher.Write(binary.BigEndian.AppendUint64(nil, uint64(ethlog.Index)))
+		for _, topic := range ethlog.Topics {
+			hasher.Write(topic.Bytes())
+		}
+		hasher.Write(crypto.Keccak256(ethlog.Data))
+	}
+
+	var hash common.Hash
+	hasher.Read(hash[:])
+	return hash
+}
+
+// quorumLogs fetches a block range from several backends and only returns the
+// logs once at least quorum of them returned the same log set. The best
+// backends are asked first, the others only if those disagree.
+func (b *Backend) quorumLogs(fromBlock, toBlock int64, addresses []common.Address) ([]types.Log, error) {
+	clients := b.pool.Available(toBlock)
+	if len(clients) < b.quorum {
+		return nil, fmt.Errorf("quorum %v not reachable, %v backends available", b.quorum, len(clients))
+	}
+
+	results := make([]*quorumResult, len(clients))
+	for idx, cli := range clients {
+		results[idx] = &quorumResult{cli: cli}
+	}
+
+	fetch := func(results []*quorumResult) {
+		wg := sync.WaitGroup{}
+		for _, result := range results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result.logs, result.err = b.filterLogs(fromBlock, toBlock, addresses, result.cli)
+				result.hash = logsHash(result.logs)
+				result.fetched = true
+			}()
+		}
+		wg.Wait()
+	}
+
+	fetch(results[:b.quorum])
+	best, votes := quorumVotes(results)
+	if votes < b.quorum {
+		fetch(results[b.quorum:])
+		best, votes = quorumVotes(results)
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("error quorum range [%v,%v]: %w", fromBlock, toBlock, results[0].err)
+	}
+
+	for _, result := range results {
+		if !result.fetched || result.err != nil || result.hash == best.hash {
+			continue
+		}
+		b.logger.Warn(fmt.Sprintf("quorum mismatch range [%v,%v]", fromBlock, toBlock), "url", result.cli.Url(), "size", len(result.logs), "expect", len(best.logs), "chain", b.chain)
+		gQuorumMismatchMap.Store(b.chain, b.quorumMismatches.Add(1))
+	}
+
+	if votes < b.quorum {
+		return nil, fmt.Errorf("quorum not reached for range [%v,%v], %v of %v agreed", fromBlock, toBlock, votes, b.quorum)
+	}
+	return best.logs, nil
+}
+
+// quorumVotes returns the log set returned by most backends so far.
+func quorumVotes(results []*quorumResult) (*quorumResult, int) {
+	var best *quorumResult
+	var votes int
+	counts := map[common.Hash]int{}
+	for _, result := range results {
+		if !result.fetched || result.err != nil {
+			continue
+		}
+		counts[result.hash]++
+		if counts[result.hash] > votes {
+			best = result
+			votes = counts[result.hash]
+		}
+	}
+	return best, votes
+}
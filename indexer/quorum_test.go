@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func quorumBackend(t *testing.T, quorum int, logSets ...[]types.Log) *Backend {
+	var clients []*web3.Web3
+	for _, ethlogs := range logSets {
+		node, cli := newStubNode(t)
+		node.serveHead(200, nil)
+		node.handle("eth_getLogs", func([]json.RawMessage) (any, error) {
+			return ethlogs, nil
+		})
+		clients = append(clients, cli)
+	}
+
+	b := newStoreBackend("quorum-test", memorydb.New(), false)
+	b.quorum = quorum
+	b.rangeSize = newAdaptiveRange(1000)
+	b.pool = newClientPool(clients, BalanceScore)
+	if _, err := b.pool.Heads(); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestQuorumLogs(t *testing.T) {
+	op := userOpLog(t, common.HexToHash("0x01"), 100, common.HexToHash("0xa1"), 0, true)
+	other := userOpLog(t, common.HexToHash("0x02"), 100, common.HexToHash("0xa1"), 0, true)
+	agreed := []types.Log{op}
+
+	// two of three backends agree, the third one is outvoted
+	b := quorumBackend(t, 2, agreed, agreed, []types.Log{other})
+	ethlogs, err := b.quorumLogs(100, 110, b.entryPoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ethlogs) != 1 || logsHash(ethlogs) != logsHash(agreed) {
+		t.Fatalf("quorum returned %v logs, want the agreed set", len(ethlogs))
+	}
+
+	// no two backends agree
+	b = quorumBackend(t, 2, agreed, []types.Log{other}, nil)
+	if _, err := b.quorumLogs(100, 110, b.entryPoints); err == nil || !strings.Contains(err.Error(), "quorum not reached") {
+		t.Fatalf("quorumLogs without agreement = %v", err)
+	}
+	if mismatches := b.quorumMismatches.Load(); mismatches != 2 {
+		t.Fatalf("%v quorum mismatches, want 2", mismatches)
+	}
+
+	// the same log in blocks of two forks
+	forked := userOpLog(t, common.HexToHash("0x01"), 100, common.HexToHash("0xb1"), 0, true)
+	b = quorumBackend(t, 2, agreed, []types.Log{forked})
+	if _, err := b.quorumLogs(100, 110, b.entryPoints); err == nil {
+		t.Fatal("quorum reached by backends on different forks")
+	}
+
+	// not enough backends to reach the quorum
+	b = quorumBackend(t, 3, agreed, agreed)
+	if _, err := b.quorumLogs(100, 110, b.entryPoints); err == nil {
+		t.Fatal("quorum of 3 reached with 2 backends")
+	}
+}
+
+func TestValidQuorum(t *testing.T) {
+	backends := []string{"http://a", "http://b"}
+	for quorum, valid := range map[int]bool{-1: false, 0: true, 1: true, 2: true, 3: false} {
+		chain := ChainCfg{Chain: "quorum-test", Backends: backends, Quorum: quorum}
+		if err := chain.validQuorum(); (err == nil) != valid {
+			t.Errorf("quorum %v: err %v, want valid %v", quorum, err, valid)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// rangeGrowAfter is the number of consecutive successful full windows after
+// which a shrunk eth_getLogs block range is doubled again.
+const rangeGrowAfter = 10
+
+var (
+	// _rangeLimitErrors are the exact, lower cased, messages providers reject
+	// a block range or result size with
+	_rangeLimitErrors = []string{
+		"query returned more than",              // infura: query returned more than 10000 results
+		"log response size exceeded",            // alchemy
+		"block range is too wide",               // ankr, bsc
+		"block range too large",                 // erigon
+		"exceed maximum block range",            // blockpi, bsc
+		"exceeds maximum block range",           // geth based
+		"eth_getlogs is limited to a",           // quicknode: eth_getLogs is limited to a 10,000 range
+		"eth_newfilter are limited to a",        // quicknode: eth_getLogs and eth_newFilter are limited to a 10,000 blocks range
+		"response size should not greater than", // nodereal
+		"query timeout exceeded",                // geth
+	}
+
+	_rangeSuggestion = regexp.MustCompile(`\[(0x[0-9a-fA-F]+),\s*(0x[0-9a-fA-F]+)\]`)
+)
+
+// isRangeLimitError reports whether an eth_getLogs error is a provider limit
+// on the block range or the result size, which may be avoided by querying a
+// smaller range.
+func isRangeLimitError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, limit := range _rangeLimitErrors {
+		if strings.Contains(msg, limit) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTimeoutError reports whether a request timed out.
+func isTimeoutError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// suggestedToBlock returns the end of the block range some providers suggest
+// in their limit errors, e.g. "Try with this block range [0x1, 0x2]".
+func suggestedToBlock(err error, fromBlock, toBlock int64) (int64, bool) {
+	match := _rangeSuggestion.FindStringSubmatch(err.Error())
+	if len(match) != 3 {
+		return 0, false
+	}
+	suggestedFrom, err1 := hexutil.DecodeUint64(match[1])
+	suggestedTo, err2 := hexutil.DecodeUint64(match[2])
+	if err1 != nil || err2 != nil || int64(suggestedFrom) != fromBlock {
+		return 0, false
+	}
+	if int64(suggestedTo) < fromBlock || int64(suggestedTo) >= toBlock {
+		return 0, false
+	}
+	return int64(suggestedTo), true
+}
+
+// adaptiveRange is the effective eth_getLogs block range of a chain. It is
+// halved when a provider rejects a range and grows back up to the configured
+// size after a run of successes.
+type adaptiveRange struct {
+	lock      sync.Mutex
+	max       int64
+	size      int64
+	successes int
+}
+
+func newAdaptiveRange(max int64) *adaptiveRange {
+	return &adaptiveRange{max: max, size: max}
+}
+
+func (r *adaptiveRange) Size() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.size
+}
+
+// Shrink records that a range of the given size was rejected.
+func (r *adaptiveRange) Shrink(failed int64) int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.successes = 0
+	size := failed / 2
+	if size < 1 {
+		size = 1
+	}
+	if size < r.size {
+		r.size = size
+	}
+	return r.size
+}
+
+// Success records that a range of the given size was fetched.
+func (r *adaptiveRange) Success(size int64) int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if size < r.size || r.size >= r.max {
+		return r.size
+	}
+	r.successes++
+	if r.successes >= rangeGrowAfter {
+		r.successes = 0
+		r.size *= 2
+		if r.size > r.max {
+			r.size = r.max
+		}
+	}
+	return r.size
+}
@@ -0,0 +1,136 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+)
+
+func TestIsRangeLimitError(t *testing.T) {
+	tests := []struct {
+		err   string
+		limit bool
+	}{
+		{"query returned more than 10000 results. Try with this block range [0x2D74EB2, 0x2D74F3C].", true},
+		{"Log response size exceeded. You can make eth_getLogs requests with up to a 2K block range and no limit on the response size", true},
+		{"eth_getLogs is limited to a 10,000 range", true},
+		{"block range is too wide", true},
+		{"exceed maximum block range: 5000", true},
+		{"block range too large", true},
+		{"requested range exceeds maximum block range of 10000", true},
+		{"eth_getLogs and eth_newFilter are limited to a 10,000 blocks range", true},
+		{"response size should not greater than 10000000 bytes", true},
+		{"query timeout exceeded", true},
+		{"your plan is limited to a 10 requests per second", false},
+		{"response size of the request body exceeds the limit", false},
+		{"too many results in the batch", false},
+		{"429 Too Many Requests: rate limit exceeded", false},
+		{"context deadline exceeded", false},
+		{"invalid block range", false},
+		{"invalid block range params: fromBlock is greater than toBlock", false},
+		{"block range extends beyond current head block", false},
+	}
+	for _, test := range tests {
+		if limit := isRangeLimitError(errors.New(test.err)); limit != test.limit {
+			t.Errorf("isRangeLimitError(%q) = %v, want %v", test.err, limit, test.limit)
+		}
+	}
+}
+
+func TestIsTimeoutError(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 0)
+	defer cancelFunc()
+	<-ctx.Done()
+
+	tests := []struct {
+		err     error
+		timeout bool
+	}{
+		{ctx.Err(), true},
+		{fmt.Errorf("eth_getTransactionReceipt: %w", &url.Error{Op: "Post", URL: "http://node", Err: context.DeadlineExceeded}), true},
+		{errors.New("query timeout exceeded"), false},
+		{context.Canceled, false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if timeout := isTimeoutError(test.err); timeout != test.timeout {
+			t.Errorf("isTimeoutError(%v) = %v, want %v", test.err, timeout, test.timeout)
+		}
+	}
+}
+
+func TestSuggestedToBlock(t *testing.T) {
+	err := errors.New("query returned more than 10000 results. Try with this block range [0x2D74EB2, 0x2D74F3C].")
+	toBlock, ok := suggestedToBlock(err, 0x2D74EB2, 0x2D75000)
+	if !ok || toBlock != 0x2D74F3C {
+		t.Errorf("suggestedToBlock = %v, %v, want %v", toBlock, ok, 0x2D74F3C)
+	}
+
+	if _, ok := suggestedToBlock(err, 0x2D74EB3, 0x2D75000); ok {
+		t.Errorf("suggestedToBlock accepted a suggestion for another range")
+	}
+}
+
+func TestAdaptiveRange(t *testing.T) {
+	r := newAdaptiveRange(1000)
+	if size := r.Shrink(1000); size != 500 {
+		t.Fatalf("size after shrink = %v, want 500", size)
+	}
+	if size := r.Shrink(1000); size != 500 {
+		t.Fatalf("size after shrinking a larger range = %v, want 500", size)
+	}
+
+	for i := 0; i < rangeGrowAfter-1; i++ {
+		r.Success(500)
+	}
+	r.Success(100)
+	if size := r.Size(); size != 500 {
+		t.Fatalf("size before grow = %v, want 500", size)
+	}
+	if size := r.Success(500); size != 1000 {
+		t.Fatalf("size after grow = %v, want 1000", size)
+	}
+	for i := 0; i < rangeGrowAfter; i++ {
+		r.Success(1000)
+	}
+	if size := r.Size(); size != 1000 {
+		t.Fatalf("size = %v, grew past the configured range", size)
+	}
+}
+
+func TestFilterLogsRangeSize(t *testing.T) {
+	node, cli := newStubNode(t)
+	node.serveHead(2000, nil)
+	b := newStoreBackend("range-test", memorydb.New(), false)
+	b.rangeSize = newAdaptiveRange(1000)
+	b.pool = newClientPool([]*web3.Web3{cli}, BalanceScore)
+
+	// a provider error that isn't about the range keeps the size
+	node.handle("eth_getLogs", func([]json.RawMessage) (any, error) {
+		return nil, errors.New("your plan is limited to a 10 requests per second")
+	})
+	if _, err := b.filterLogs(0, 999, b.entryPoints, cli); err == nil {
+		t.Fatal("filterLogs succeeded on an error")
+	}
+	if size := b.rangeSize.Size(); size != 1000 {
+		t.Fatalf("range size %v after a non range error, want 1000", size)
+	}
+	if calls := node.count("eth_getLogs"); calls != 1 {
+		t.Fatalf("%v eth_getLogs calls after a non range error, want 1", calls)
+	}
+
+	// a range limit shrinks it
+	node.handle("eth_getLogs", func([]json.RawMessage) (any, error) {
+		return nil, errors.New("eth_getLogs is limited to a 10,000 range")
+	})
+	b.filterLogs(0, 999, b.entryPoints, cli)
+	if size := b.rangeSize.Size(); size >= 1000 {
+		t.Fatalf("range size %v after a range limit error, want it shrunk", size)
+	}
+}
@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// SpaceReceipt holds the receipt of each bundle transaction.
+	SpaceReceipt = "receipt"
+	// SpaceUserOpReceipt holds the receipt of each user operation, without the
+	// transaction receipt, which is joined from SpaceReceipt when served.
+	SpaceUserOpReceipt = "op-receipt"
+)
+
+// UserOperationReceipt is the eth_getUserOperationReceipt result.
+type UserOperationReceipt struct {
+	UserOpHash    common.Hash     `json:"userOpHash"`
+	EntryPoint    common.Address  `json:"entryPoint"`
+	Sender        common.Address  `json:"sender"`
+	Nonce         *hexutil.Big    `json:"nonce"`
+	Paymaster     common.Address  `json:"paymaster"`
+	ActualGasCost *hexutil.Big    `json:"actualGasCost"`
+	ActualGasUsed *hexutil.Big    `json:"actualGasUsed"`
+	Success       bool            `json:"success"`
+	Reason        hexutil.Bytes   `json:"reason,omitempty"`
+	Logs          []*types.Log    `json:"logs"`
+	Receipt       json.RawMessage `json:"receipt,omitempty"`
+}
+
+// txReceipt is the part of a transaction receipt needed to split its logs.
+type txReceipt struct {
+	Logs []*types.Log `json:"logs"`
+}
+
+// sharedReceipt reports whether the transaction receipt of a removed log is
+// still needed by user operations of its bundle that stay indexed.
+func (b *Backend) sharedReceipt(batch database.Batch, fetched *rangeLogs, ethlog *types.Log) (bool, error) {
+	removed := map[uint]bool{}
+	for _, other := range fetched.logs {
+		if other.TxHash == ethlog.TxHash && other.Removed {
+			removed[other.Index] = true
+		}
+	}
+
+	key := DbKeyEvent(b.chain, SpaceBundle, ethlog.TxHash.Hex())
+	data, err := b.get(batch, key)
+	if err != nil || len(data) == 0 {
+		return false, err
+	}
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return false, fmt.Errorf("error decode db key %s: %w", key, err)
+	}
+	if bundle.BlockHash != ethlog.BlockHash {
+		return false, nil
+	}
+	for _, op := range bundle.UserOperations {
+		if !removed[uint(op.LogIndex)] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// UserOperationLogs returns the logs a user operation emitted within its
+// bundle: the logs between the BeforeExecution or previous
+// UserOperationEvent log and its own UserOperationEvent log.
+func UserOperationLogs(logs []*types.Log, entryPoint common.Address, userOpHash common.Hash) ([]*types.Log, error) {
+	beforeExecution := EntryPointAbi.Events["BeforeExecution"].ID
+	userOperationEvent := EntryPointAbi.Events["UserOperationEvent"].ID
+
+	start := -1
+	for idx, ethlog := range logs {
+		if ethlog.Address != entryPoint || len(ethlog.Topics) == 0 {
+			continue
+		}
+		switch {
+		case ethlog.Topics[0] == beforeExecution:
+			// the execution of the user operations starts after BeforeExecution
+			start = idx
+		case ethlog.Topics[0] == userOperationEvent && len(ethlog.Topics) > 1:
+			if ethlog.Topics[1] == userOpHash {
+				return logs[start+1 : idx], nil
+			}
+			start = idx
+		}
+	}
+	return nil, errors.New("UserOperationEvent not found in receipt " + userOpHash.Hex())
+}
+
+// revertReason returns the revert reason of a user operation emitted in the
+// receipt logs, if any.
+func revertReason(logs []*types.Log, entryPoint common.Address, userOpHash common.Hash) hexutil.Bytes {
+	topic := EntryPointAbi.Events["UserOperationRevertReason"].ID
+	for _, ethlog := range logs {
+		if ethlog.Address != entryPoint || len(ethlog.Topics) < 2 || ethlog.Topics[0] != topic || ethlog.Topics[1] != userOpHash {
+			continue
+		}
+		values, err := EntryPointAbi.Unpack("UserOperationRevertReason", ethlog.Data)
+		if err != nil || len(values) < 2 {
+			return nil
+		}
+		reason, _ := values[1].([]byte)
+		return reason
+	}
+	return nil
+}
+
+// userOperationReceipt returns the receipt record of a UserOperationEvent, or
+// nil if the receipt of its transaction was not fetched.
+func (b *Backend) userOperationReceipt(fetched *rangeLogs, event *UserOperationEvent) ([]byte, error) {
+	raw := fetched.receipts[event.TransactionHash]
+	if raw == nil {
+		return nil, nil
+	}
+	receipt := &txReceipt{}
+	if err := json.Unmarshal(raw, receipt); err != nil {
+		return nil, err
+	}
+	logs, err := UserOperationLogs(receipt.Logs, event.EntryPoint, event.UserOpHash)
+	if err != nil {
+		return nil, err
+	}
+	if logs == nil {
+		logs = []*types.Log{}
+	}
+
+	record, _ := json.Marshal(&UserOperationReceipt{
+		UserOpHash:    event.UserOpHash,
+		EntryPoint:    event.EntryPoint,
+		Sender:        event.Sender,
+		Nonce:         event.Nonce,
+		Paymaster:     event.Paymaster,
+		ActualGasCost: event.ActualGasCost,
+		ActualGasUsed: event.ActualGasUsed,
+		Success:       event.Success,
+		Reason:        revertReason(receipt.Logs, event.EntryPoint, event.UserOpHash),
+		Logs:          logs,
+	})
+	return record, nil
+}
@@ -0,0 +1,52 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestUserOperationLogs(t *testing.T) {
+	entryPoint := common.HexToAddress("0x5ff137d4b0fdcd49dca30c7cf57e578a026d2789")
+	token := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	op1, op2 := common.HexToHash("0x01"), common.HexToHash("0x02")
+	beforeExecution := EntryPointAbi.Events["BeforeExecution"].ID
+	userOperationEvent := EntryPointAbi.Events["UserOperationEvent"].ID
+
+	logs := []*types.Log{
+		{Address: entryPoint, Topics: []common.Hash{EntryPointAbi.Events["Deposited"].ID}, Index: 0},
+		{Address: entryPoint, Topics: []common.Hash{beforeExecution}, Index: 1},
+		{Address: token, Topics: []common.Hash{{}}, Index: 2},
+		{Address: token, Topics: []common.Hash{{}}, Index: 3},
+		{Address: entryPoint, Topics: []common.Hash{userOperationEvent, op1, {}, {}}, Index: 4},
+		{Address: token, Topics: []common.Hash{{}}, Index: 5},
+		{Address: entryPoint, Topics: []common.Hash{userOperationEvent, op2, {}, {}}, Index: 6},
+	}
+
+	tests := []struct {
+		op      common.Hash
+		indexes []uint
+	}{
+		{op1, []uint{2, 3}},
+		{op2, []uint{5}},
+	}
+	for _, test := range tests {
+		got, err := UserOperationLogs(logs, entryPoint, test.op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(test.indexes) {
+			t.Fatalf("op %s: got %d logs, want %d", test.op.Hex(), len(got), len(test.indexes))
+		}
+		for i, ethlog := range got {
+			if ethlog.Index != test.indexes[i] {
+				t.Errorf("op %s: log %d has index %d, want %d", test.op.Hex(), i, ethlog.Index, test.indexes[i])
+			}
+		}
+	}
+
+	if _, err := UserOperationLogs(logs, entryPoint, common.HexToHash("0x03")); err == nil {
+		t.Error("expected error for unknown user operation")
+	}
+}
@@ -0,0 +1,179 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// SpaceUserOpEvent holds the decoded UserOperationEvent of each user
+	// operation.
+	SpaceUserOpEvent = "op-event"
+	// SpaceSender lists the user operation hashes of each sender, ordered by
+	// block number and log index.
+	SpaceSender = "sender"
+)
+
+// UserOperationEvent is a decoded UserOperationEvent log.
+type UserOperationEvent struct {
+	UserOpHash      common.Hash    `json:"userOpHash"`
+	EntryPoint      common.Address `json:"entryPoint"`
+	Sender          common.Address `json:"sender"`
+	Paymaster       common.Address `json:"paymaster"`
+	Nonce           *hexutil.Big   `json:"nonce"`
+	Success         bool           `json:"success"`
+	ActualGasCost   *hexutil.Big   `json:"actualGasCost"`
+	ActualGasUsed   *hexutil.Big   `json:"actualGasUsed"`
+	BlockNumber     hexutil.Uint64 `json:"blockNumber"`
+	BlockHash       common.Hash    `json:"blockHash"`
+	BlockTimestamp  hexutil.Uint64 `json:"blockTimestamp"`
+	TransactionHash common.Hash    `json:"transactionHash"`
+	LogIndex        hexutil.Uint   `json:"logIndex"`
+}
+
+// rangeLogs are the logs of a block range together with the chain data
+// needed to index them.
+type rangeLogs struct {
+	logs         []types.Log
+	timestamps   map[common.Hash]uint64
+	transactions map[common.Hash]*bundleTx
+	userOps      map[common.Hash][]*entrypoint.UserOperation
+	receipts     map[common.Hash]json.RawMessage
+}
+
+func isUserOperationEvent(ethlog *types.Log) bool {
+	return len(ethlog.Topics) == 4 && ethlog.Topics[0] == EntryPointAbi.Events["UserOperationEvent"].ID
+}
+
+// DecodeUserOperationEvent decodes a UserOperationEvent log.
+func DecodeUserOperationEvent(ethlog *types.Log, timestamp uint64) (*UserOperationEvent, error) {
+	if !isUserOperationEvent(ethlog) {
+		return nil, errors.New("not a UserOperationEvent log")
+	}
+
+	values, err := EntryPointAbi.Unpack("UserOperationEvent", ethlog.Data)
+	if err != nil {
+		return nil, err
+	}
+	nonce, _ := values[0].(*big.Int)
+	success, _ := values[1].(bool)
+	actualGasCost, _ := values[2].(*big.Int)
+	actualGasUsed, _ := values[3].(*big.Int)
+
+	return &UserOperationEvent{
+		UserOpHash:      ethlog.Topics[1],
+		EntryPoint:      ethlog.Address,
+		Sender:          common.BytesToAddress(ethlog.Topics[2].Bytes()),
+		Paymaster:       common.BytesToAddress(ethlog.Topics[3].Bytes()),
+		Nonce:           (*hexutil.Big)(nonce),
+		Success:         success,
+		ActualGasCost:   (*hexutil.Big)(actualGasCost),
+		ActualGasUsed:   (*hexutil.Big)(actualGasUsed),
+		BlockNumber:     hexutil.Uint64(ethlog.BlockNumber),
+		BlockHash:       ethlog.BlockHash,
+		BlockTimestamp:  hexutil.Uint64(timestamp),
+		TransactionHash: ethlog.TxHash,
+		LogIndex:        hexutil.Uint(ethlog.Index),
+	}, nil
+}
+
+// enrichRange is enrich for the logs of [fromBlock, toBlock]. A timeout
+// shrinks the adaptive range like a provider limit on eth_getLogs does, as the
+// range holds more transactions than the backend serves in time.
+func (b *Backend) enrichRange(fromBlock, toBlock int64, ethlogs []types.Log, cli *web3.Web3) (*rangeLogs, error) {
+	fetched, err := b.enrich(ethlogs, cli)
+	if isTimeoutError(err) && fromBlock < toBlock {
+		b.setRangeSize(b.rangeSize.Shrink(toBlock - fromBlock + 1))
+		b.logger.Warn(fmt.Sprintf("shrink logs range [%v,%v] after a timeout", fromBlock, toBlock), "err", err, "size", b.rangeSize.Size(), "url", cli.Url(), "chain", b.chain)
+	}
+	return fetched, err
+}
+
+// enrich fetches the chain data needed to index the logs, each batch of calls
+// within _httpTimeout.
+func (b *Backend) enrich(ethlogs []types.Log, cli *web3.Web3) (*rangeLogs, error) {
+	ctx := context.Background()
+
+	result := &rangeLogs{
+		logs:         ethlogs,
+		timestamps:   map[common.Hash]uint64{},
+		transactions: map[common.Hash]*bundleTx{},
+		userOps:      map[common.Hash][]*entrypoint.UserOperation{},
+		receipts:     map[common.Hash]json.RawMessage{},
+	}
+
+	var args, txArgs [][]any
+	for _, ethlog := range ethlogs {
+		if ethlog.Removed || !isUserOperationEvent(&ethlog) {
+			continue
+		}
+		if _, ok := result.transactions[ethlog.TxHash]; !ok {
+			result.transactions[ethlog.TxHash] = nil
+			txArgs = append(txArgs, []any{ethlog.TxHash})
+		}
+		if _, ok := result.timestamps[ethlog.BlockHash]; ok {
+			continue
+		}
+		result.timestamps[ethlog.BlockHash] = 0
+		args = append(args, []any{ethlog.BlockHash, false})
+	}
+
+	blocks := make([]*struct {
+		Hash      common.Hash    `json:"hash"`
+		Timestamp hexutil.Uint64 `json:"timestamp"`
+	}, len(args))
+	results := make([]any, len(args))
+	for i := range blocks {
+		results[i] = &blocks[i]
+	}
+	if err := cli.BatchCall(ctx, _httpTimeout, "eth_getBlockByHash", args, results); err != nil {
+		return nil, err
+	}
+	for i, block := range blocks {
+		if block == nil {
+			return nil, errors.New("block not found " + args[i][0].(common.Hash).Hex())
+		}
+		result.timestamps[block.Hash] = uint64(block.Timestamp)
+	}
+
+	txs := make([]*bundleTx, len(txArgs))
+	results = make([]any, len(txArgs))
+	for i := range txs {
+		results[i] = &txs[i]
+	}
+	if err := cli.BatchCall(ctx, _httpTimeout, "eth_getTransactionByHash", txArgs, results); err != nil {
+		return nil, err
+	}
+	for i, tx := range txs {
+		if tx == nil {
+			return nil, errors.New("transaction not found " + txArgs[i][0].(common.Hash).Hex())
+		}
+		result.transactions[tx.Hash] = tx
+	}
+
+	receipts := make([]json.RawMessage, len(txArgs))
+	results = make([]any, len(txArgs))
+	for i := range receipts {
+		results[i] = &receipts[i]
+	}
+	if err := cli.BatchCall(ctx, _httpTimeout, "eth_getTransactionReceipt", txArgs, results); err != nil {
+		return nil, err
+	}
+	for i, receipt := range receipts {
+		if len(receipt) == 0 || string(receipt) == "null" {
+			return nil, errors.New("receipt not found " + txArgs[i][0].(common.Hash).Hex())
+		}
+		result.receipts[txArgs[i][0].(common.Hash)] = receipt
+	}
+
+	return result, nil
+}
@@ -0,0 +1,257 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const DefaultReorgDepth = 128
+
+// indexedBlock is a block the backend has indexed, together with the db keys
+// written for it, so they can be removed again if the block gets orphaned.
+// Each block is stored in its own record.
+type indexedBlock struct {
+	Number int64       `json:"number"`
+	Hash   common.Hash `json:"hash"`
+	Keys   []string    `json:"keys,omitempty"`
+	// dirty tells whether the block changed since it was stored
+	dirty bool
+}
+
+// blockWindow is the rolling window of the most recently indexed blocks of a
+// chain, ordered by block number.
+type blockWindow struct {
+	Blocks []*indexedBlock
+	// dropped are the blocks rolled back since the window was stored
+	dropped []int64
+}
+
+func (w *blockWindow) search(number int64) int {
+	return sort.Search(len(w.Blocks), func(i int) bool {
+		return w.Blocks[i].Number >= number
+	})
+}
+
+func (w *blockWindow) get(number int64) *indexedBlock {
+	idx := w.search(number)
+	if idx < len(w.Blocks) && w.Blocks[idx].Number == number {
+		return w.Blocks[idx]
+	}
+	return nil
+}
+
+func (w *blockWindow) latest() *indexedBlock {
+	if len(w.Blocks) == 0 {
+		return nil
+	}
+	return w.Blocks[len(w.Blocks)-1]
+}
+
+// add records the hash of an indexed block and, optionally, a db key written
+// for it.
+func (w *blockWindow) add(number int64, hash common.Hash, key string) {
+	block := w.get(number)
+	if block == nil {
+		block = &indexedBlock{Number: number, dirty: true}
+		idx := w.search(number)
+		w.Blocks = append(w.Blocks, nil)
+		copy(w.Blocks[idx+1:], w.Blocks[idx:])
+		w.Blocks[idx] = block
+	}
+	if block.Hash != hash {
+		block.Hash = hash
+		block.Keys = nil
+		block.dirty = true
+	}
+	if len(key) > 0 {
+		for _, k := range block.Keys {
+			if k == key {
+				return
+			}
+		}
+		block.Keys = append(block.Keys, key)
+		block.dirty = true
+	}
+}
+
+// prune drops all blocks below number, their records are trimmed when the
+// window is saved.
+func (w *blockWindow) prune(number int64) {
+	idx := w.search(number)
+	w.Blocks = append([]*indexedBlock(nil), w.Blocks[idx:]...)
+}
+
+// rollback drops and returns all blocks above number.
+func (w *blockWindow) rollback(number int64) []*indexedBlock {
+	idx := w.search(number + 1)
+	removed := w.Blocks[idx:]
+	w.Blocks = w.Blocks[:idx]
+	for _, block := range removed {
+		w.dropped = append(w.dropped, block.Number)
+	}
+	return removed
+}
+
+func (b *Backend) loadWindow() (*blockWindow, error) {
+	if b.window != nil {
+		return b.window, nil
+	}
+
+	window := &blockWindow{}
+	err := b.db.Iterate(b.windowPrefix, "", false, func(key string, value []byte) bool {
+		block := &indexedBlock{}
+		if err := json.Unmarshal(value, block); err != nil {
+			b.logger.Warn("invalid reorg window block, dropped", "key", key, "err", err, "chain", b.chain)
+			return true
+		}
+		window.Blocks = append(window.Blocks, block)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error iterate db prefix %s: %w", b.windowPrefix, err)
+	}
+	b.window = window
+	return window, nil
+}
+
+// saveWindow writes the changed blocks of the window, deletes the rolled back
+// ones and trims the records below the window.
+func (b *Backend) saveWindow(batch database.Batch) error {
+	window := b.window
+	for _, number := range window.dropped {
+		if err := batch.Delete(DbKeyReorgWindow(b.chain, number)); err != nil {
+			return err
+		}
+	}
+	window.dropped = nil
+
+	for _, block := range window.Blocks {
+		if !block.dirty {
+			continue
+		}
+		data, _ := json.Marshal(block)
+		if err := batch.Put(DbKeyReorgWindow(b.chain, block.Number), data, false); err != nil {
+			return err
+		}
+		block.dirty = false
+	}
+
+	if len(window.Blocks) == 0 {
+		return nil
+	}
+	// records are ordered by block number, the pruned ones come first
+	bound := DbKeyReorgWindow(b.chain, window.Blocks[0].Number)
+	var pruned []string
+	err := b.db.Iterate(b.windowPrefix, "", false, func(key string, value []byte) bool {
+		if key >= bound {
+			return false
+		}
+		pruned = append(pruned, key)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error iterate db prefix %s: %w", b.windowPrefix, err)
+	}
+	for _, key := range pruned {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkReorg compares the latest indexed block with the canonical chain. If it
+// was replaced, it walks back the window to the fork point, deletes everything
+// indexed from the orphaned blocks and rewinds the cursors and the ledgers to
+// the fork point, all in one batch.
+func (b *Backend) checkReorg(ctx context.Context, cli *web3.Web3) (bool, error) {
+	window, err := b.loadWindow()
+	if err != nil {
+		return false, err
+	}
+
+	latest := window.latest()
+	if latest == nil {
+		return false, nil
+	}
+
+	header, err := cli.Cli().HeaderByNumber(ctx, big.NewInt(latest.Number))
+	if err != nil {
+		return false, err
+	}
+	if header.Hash() == latest.Hash {
+		return false, nil
+	}
+
+	fork := window.Blocks[0].Number - 1
+	for i := len(window.Blocks) - 2; i >= 0; i-- {
+		header, err = cli.Cli().HeaderByNumber(ctx, big.NewInt(window.Blocks[i].Number))
+		if err != nil {
+			return false, err
+		}
+		if header.Hash() == window.Blocks[i].Hash {
+			fork = window.Blocks[i].Number
+			break
+		}
+	}
+
+	removed := window.rollback(fork)
+	if len(window.Blocks) == 0 {
+		b.logger.Warn("reorg deeper than the reorg window", "depth", b.reorgDepth, "chain", b.chain)
+	}
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	batch := b.db.NewBatch()
+	var keys int
+	for _, block := range removed {
+		for _, key := range block.Keys {
+			if err := b.deleteKey(batch, key); err != nil {
+				b.window = nil
+				return false, fmt.Errorf("error delete db key %s: %w", key, err)
+			}
+			keys++
+		}
+	}
+	if err := b.saveWindow(batch); err != nil {
+		b.window = nil
+		return false, err
+	}
+	if err := b.truncateRanges(batch, fork); err != nil {
+		b.window = nil
+		return false, err
+	}
+
+	b.cursorLock.Lock()
+	defer b.cursorLock.Unlock()
+
+	rewound := b.rewindLagging(batch, fork)
+	// pushed logs may be ahead of the cursor, which must never move forward here
+	rewind := fork < b.nextBlock()
+	if rewind && !b.backfilling {
+		b.putStartBlock(batch, fork)
+	}
+	if err := batch.Write(); err != nil {
+		b.window = nil
+		return false, fmt.Errorf("error write reorg rollback: %w", err)
+	}
+
+	for _, address := range rewound {
+		b.lagging[address] = fork
+	}
+	if rewind {
+		b.moveStartBlock(fork)
+	}
+	b.storeEntryPointStatus()
+
+	b.logger.Warn("chain reorg detected", "fork", fork, "orphaned", len(removed), "deleted", keys, "chain", b.chain)
+	return true, nil
+}
@@ -0,0 +1,263 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// stubNode is a json-rpc node answering each method with a handler, for
+// tests that need a *web3.Web3.
+type stubNode struct {
+	lock     sync.Mutex
+	handlers map[string]func(params []json.RawMessage) (any, error)
+	calls    map[string]int
+}
+
+func newStubNode(t *testing.T) (*stubNode, *web3.Web3) {
+	node := &stubNode{
+		handlers: map[string]func(params []json.RawMessage) (any, error){},
+		calls:    map[string]int{},
+	}
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+	cli, err := web3.NewWeb3Client(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node, cli
+}
+
+func (n *stubNode) handle(method string, fn func(params []json.RawMessage) (any, error)) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.handlers[method] = fn
+}
+
+func (n *stubNode) count(method string) int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.calls[method]
+}
+
+type stubRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (n *stubNode) call(req stubRequest) map[string]any {
+	n.lock.Lock()
+	n.calls[req.Method]++
+	fn := n.handlers[req.Method]
+	n.lock.Unlock()
+
+	resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	if fn == nil {
+		resp["error"] = map[string]any{"code": -32601, "message": "method not found"}
+		return resp
+	}
+	result, err := fn(req.Params)
+	if err != nil {
+		resp["error"] = map[string]any{"code": -32000, "message": err.Error()}
+		return resp
+	}
+	resp["result"] = result
+	return resp
+}
+
+func (n *stubNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body bytes.Buffer
+	body.ReadFrom(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+
+	if bytes.HasPrefix(bytes.TrimSpace(body.Bytes()), []byte("[")) {
+		var reqs []stubRequest
+		if err := json.Unmarshal(body.Bytes(), &reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var resps []map[string]any
+		for _, req := range reqs {
+			resps = append(resps, n.call(req))
+		}
+		json.NewEncoder(w).Encode(resps)
+		return
+	}
+	var req stubRequest
+	if err := json.Unmarshal(body.Bytes(), &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(n.call(req))
+}
+
+// stubHeader returns the header of block number on the chain named fork.
+func stubHeader(number int64, fork string) *types.Header {
+	return &types.Header{Number: big.NewInt(number), Difficulty: new(big.Int), Extra: []byte(fork)}
+}
+
+// serveHeaders answers eth_getBlockByNumber with the headers of the chain
+// named fork.
+func (n *stubNode) serveHeaders(fork string) {
+	n.handle("eth_getBlockByNumber", func(params []json.RawMessage) (any, error) {
+		var number hexutil.Big
+		if err := json.Unmarshal(params[0], &number); err != nil {
+			return nil, err
+		}
+		return stubHeader(number.ToInt().Int64(), fork), nil
+	})
+}
+
+func TestBlockWindow(t *testing.T) {
+	const reorgDepth = 4
+	window := &blockWindow{}
+	for number := int64(1); number <= 10; number++ {
+		window.add(number, stubHeader(number, "a").Hash(), fmt.Sprintf("key-%v", number))
+		window.prune(number - reorgDepth)
+	}
+	window.add(10, stubHeader(10, "a").Hash(), "key-10")
+	window.add(10, stubHeader(10, "a").Hash(), "other-10")
+
+	if len(window.Blocks) != reorgDepth+1 || window.Blocks[0].Number != 6 || window.latest().Number != 10 {
+		t.Fatalf("window holds blocks %v to %v, want 6 to 10", window.Blocks[0].Number, window.latest().Number)
+	}
+	if keys := fmt.Sprint(window.get(10).Keys); keys != "[key-10 other-10]" {
+		t.Fatalf("block 10 keys %s", keys)
+	}
+	if window.get(5) != nil {
+		t.Fatal("block 5 below the reorg depth was not pruned")
+	}
+
+	// a block indexed again on another fork drops the keys of the old one
+	window.add(9, stubHeader(9, "b").Hash(), "")
+	if block := window.get(9); block.Hash != stubHeader(9, "b").Hash() || len(block.Keys) != 0 {
+		t.Fatalf("block 9 after the fork %+v", block)
+	}
+
+	removed := window.rollback(7)
+	if len(removed) != 3 || removed[0].Number != 8 || window.latest().Number != 7 {
+		t.Fatalf("rollback to 7 removed %v blocks, latest %v", len(removed), window.latest().Number)
+	}
+}
+
+func TestCheckReorg(t *testing.T) {
+	entryPoint := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	db := memorydb.New()
+	b := newStoreBackend("reorg-test", db, false)
+	b.ranged = false
+	b.reorgDepth = 10
+	b.windowPrefix = DbKeyReorgWindowPrefix(b.chain)
+	b.entryPoints = []common.Address{entryPoint}
+	b.tailing = []common.Address{entryPoint}
+	b.lagging = map[common.Address]int64{}
+	b.cursors = []*entryPointCursor{{address: entryPoint, dbKey: DbKeyStartBlock(b.chain, entryPoint.Hex())}}
+
+	// blocks 100 to 102 are indexed, 101 and 102 on a fork that gets orphaned
+	window, err := b.loadWindow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forks := map[int64]string{100: "canonical", 101: "orphaned", 102: "orphaned"}
+	ops := map[int64]common.Hash{}
+	batch := db.NewBatch()
+	for number := int64(100); number <= 102; number++ {
+		hash := stubHeader(number, forks[number]).Hash()
+		ops[number] = common.BigToHash(big.NewInt(number))
+		fetched := testRangeLogs(userOpLog(t, ops[number], uint64(number), hash, 0, true))
+		if err := b.saveLogs(batch, fetched, window); err != nil {
+			t.Fatal(err)
+		}
+		window.add(number, hash, "")
+	}
+	if err := b.saveWindow(batch); err != nil {
+		t.Fatal(err)
+	}
+	b.storeStartBlock(102)
+	b.putStartBlock(batch, 102)
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	node, cli := newStubNode(t)
+	node.serveHeaders("canonical")
+	reorged, err := b.checkReorg(context.Background(), cli)
+	if err != nil || !reorged {
+		t.Fatalf("checkReorg = %v, %v, want a reorg", reorged, err)
+	}
+
+	for number, op := range ops {
+		stored, _ := db.Has(DbKeyEvent(b.chain, SpaceUserOpEvent, op.Hex()))
+		if stored != (number == 100) {
+			t.Errorf("user operation of block %v stored %v after the rollback", number, stored)
+		}
+	}
+	if stats := paymasterStats(t, db, b.chain); stats.Count != 1 {
+		t.Errorf("paymaster stats %+v after the rollback, want 1 operation", stats)
+	}
+	if cursor, _ := db.Get(b.cursors[0].dbKey, false); string(cursor) != "100" {
+		t.Errorf("cursor %s after the rollback, want 100", cursor)
+	}
+
+	// the window is stored with the rollback
+	b.window = nil
+	window, err = b.loadWindow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := window.latest(); latest == nil || latest.Number != 100 {
+		t.Fatalf("latest indexed block %+v after the rollback, want 100", latest)
+	}
+	if records := windowRecords(t, b); records != "[100]" {
+		t.Fatalf("window records %s after the rollback, want [100]", records)
+	}
+	if reorged, err := b.checkReorg(context.Background(), cli); err != nil || reorged {
+		t.Fatalf("checkReorg on the canonical chain = %v, %v", reorged, err)
+	}
+
+	// blocks pruned from the window lose their records
+	window.add(111, stubHeader(111, "canonical").Hash(), "")
+	window.prune(111 - b.reorgDepth)
+	batch = db.NewBatch()
+	if err := b.saveWindow(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if records := windowRecords(t, b); records != "[111]" {
+		t.Fatalf("window records %s after pruning, want [111]", records)
+	}
+}
+
+// windowRecords lists the block numbers of the stored reorg window records.
+func windowRecords(t *testing.T, b *Backend) string {
+	var numbers []int64
+	err := b.db.Iterate(b.windowPrefix, "", false, func(key string, value []byte) bool {
+		block := &indexedBlock{}
+		if err := json.Unmarshal(value, block); err != nil {
+			t.Fatal(err)
+		}
+		if key != DbKeyReorgWindow(b.chain, block.Number) {
+			t.Fatalf("window record %s holds block %v", key, block.Number)
+		}
+		numbers = append(numbers, block.Number)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprint(numbers)
+}
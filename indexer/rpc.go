@@ -3,40 +3,54 @@ package indexer
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/rpc"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/golang/snappy"
 	"golang.org/x/exp/slices"
 )
 
 type Rpc interface {
 	Db() database.KVStore
-	EntryPoints() []string
+	EntryPoints(chain string) []string
 	Compressed() bool
 }
 
 func eth_getLogsByUserOperation(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
+	return getByUserOperation(s, chain, req, SpaceUserOp)
+}
+
+// indexer_getUserOperationEvents returns the decoded UserOperationEvent of
+// each user operation hash.
+func indexer_getUserOperationEvents(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
+	return getByUserOperation(s, chain, req, SpaceUserOpEvent)
+}
+
+func getByUserOperation(s Rpc, chain string, req *rpc.JsonRpcMessage, space string) *rpc.JsonRpcMessage {
 	var params []string
 	err := json.Unmarshal(req.Params, &params)
 	if err != nil || len(params) == 0 {
 		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, string(invalidRequest))
 	}
 
+	heads := loadHeads(s.Db(), chain)
 	var logs = make([][]byte, len(params))
 	for i, hash := range params {
-		data, _ := s.Db().Get(DbKeyUserOp(chain, hash), s.Compressed())
-		if data != nil && s.Compressed() {
-			decoded, err := snappy.Decode(nil, data)
-			if err == nil {
-				data = decoded
-			}
-		}
+		data := getValue(s, DbKeyEvent(chain, space, strings.ToLower(hash)))
 
 		if data == nil {
 			data = []byte("null")
+		} else {
+			data = withFinality(data, heads)
 		}
 		logs[i] = data
 	}
@@ -48,31 +62,88 @@ func eth_getLogsByUserOperation(s Rpc, chain string, req *rpc.JsonRpcMessage) *r
 	return resp
 }
 
+// _maxLogsResults caps the logs returned for a list key space
+const _maxLogsResults = 10000
+
+func decodeValue(s Rpc, data []byte) []byte {
+	if data != nil && s.Compressed() {
+		decoded, err := snappy.Decode(nil, data)
+		if err == nil {
+			data = decoded
+		}
+	}
+	return data
+}
+
+func getValue(s Rpc, key string) []byte {
+	data, _ := s.Db().Get(key, s.Compressed())
+	return decodeValue(s, data)
+}
+
+func parseBlockNumber(str string, defaultValue uint64) (uint64, error) {
+	switch str {
+	case "", "latest", "pending", "safe", "finalized":
+		return defaultValue, nil
+	case "earliest":
+		return 0, nil
+	}
+	return hexutil.DecodeUint64(str)
+}
+
+// parseCursor parses a pagination cursor, the %016x:%08x block number and log
+// index suffix of the last returned key.
+func parseCursor(cursor string) (uint64, uint, error) {
+	blockHex, indexHex, ok := strings.Cut(cursor, ":")
+	if !ok || len(blockHex) != 16 || len(indexHex) != 8 || !isLowerHex(blockHex) || !isLowerHex(indexHex) {
+		return 0, 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	blockNumber, err := strconv.ParseUint(blockHex, 16, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	logIndex, err := strconv.ParseUint(indexHex, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return blockNumber, uint(logIndex), nil
+}
+
+func isLowerHex(str string) bool {
+	for _, c := range str {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func jsonArray(items [][]byte) []byte {
+	return bytes.Join([][]byte{[]byte("["), bytes.Join(items, []byte(",")), []byte("]")}, []byte(""))
+}
+
 func eth_getLogs(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
 	param, errMsg := web3.ParseEthGetLogsRequestParams(req)
 	if errMsg != nil {
 		return errMsg
 	}
 
-	if !slices.Contains(s.EntryPoints(), strings.ToLower(param.Address)) {
+	if !slices.Contains(s.EntryPoints(chain), strings.ToLower(param.Address)) {
 		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, "address mismatch entrypoint "+param.Address)
 	}
 
 	descriptor := strings.ToLower(param.Topics[0])
-	if descriptor != LogDescriptor {
+	space, ok := _eventSpacesByTopic[common.HexToHash(descriptor)]
+	if !ok || space.KeyBy == eventKeyTransactionHash {
 		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, "invalid Log descriptor: "+descriptor)
 	}
 
-	opHash := strings.ToLower(param.Topics[1])
-	data, _ := s.Db().Get(DbKeyUserOp(chain, opHash), s.Compressed())
-
-	if data != nil && s.Compressed() {
-		decoded, err := snappy.Decode(nil, data)
-		if err == nil {
-			data = decoded
-		}
+	if space.Multi() {
+		return eth_getLogsByAddress(s, chain, req, param, space)
 	}
 
+	opHash := strings.ToLower(param.Topics[1])
+	data := getValue(s, DbKeyEvent(chain, space.Space, opHash))
+
 	if len(data) > 0 {
 		info := struct {
 			Address     string
@@ -92,9 +163,339 @@ func eth_getLogs(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessa
 		}
 	}
 
+	if len(data) > 0 {
+		data = withFinality(data, loadHeads(s.Db(), chain))
+	}
+
 	result := bytes.Join([][]byte{[]byte("["), data, []byte("]")}, []byte(""))
 
 	resp := rpc.NewJsonRpcMessage(req.ID)
 	resp.Result = result
 	return resp
 }
+
+// eth_getLogsByAddress serves eth_getLogs for the events stored as a list per
+// address, e.g. the deposits of an account.
+func eth_getLogsByAddress(s Rpc, chain string, req *rpc.JsonRpcMessage, param *web3.EthGetLogsRequestParams, space *eventSpace) *rpc.JsonRpcMessage {
+	fromBlock, err := parseBlockNumber(param.FromBlock, 0)
+	if err != nil {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32602, "invalid fromBlock: "+param.FromBlock)
+	}
+	toBlock, err := parseBlockNumber(param.ToBlock, math.MaxUint64)
+	if err != nil {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32602, "invalid toBlock: "+param.ToBlock)
+	}
+
+	address := topicAddress(common.HexToHash(param.Topics[1]))
+	prefix := DbKeyEventLogPrefix(chain, space.Space, address)
+	start := DbKeyEventLog(chain, space.Space, address, fromBlock, 0)
+
+	heads := loadHeads(s.Db(), chain)
+	var logs [][]byte
+	var tooMany bool
+	err = s.Db().Iterate(prefix, start, s.Compressed(), func(key string, data []byte) bool {
+		data = decodeValue(s, data)
+
+		info := struct {
+			Address     string
+			BlockNumber hexutil.Uint64
+		}{}
+		json.Unmarshal(data, &info)
+		if uint64(info.BlockNumber) > toBlock {
+			return false
+		}
+		if strings.ToLower(param.Address) != strings.ToLower(info.Address) {
+			return true
+		}
+		if len(logs) >= _maxLogsResults {
+			tooMany = true
+			return false
+		}
+		logs = append(logs, withFinality(data, heads))
+		return true
+	})
+	if err != nil {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, err.Error())
+	}
+	if tooMany {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32005, fmt.Sprintf("query returned more than %d results", _maxLogsResults))
+	}
+
+	resp := rpc.NewJsonRpcMessage(req.ID)
+	resp.Result = jsonArray(logs)
+	return resp
+}
+
+// indexer_getBeforeExecutionLogs returns the BeforeExecution logs of a
+// transaction.
+func indexer_getBeforeExecutionLogs(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
+	var params []string
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil || len(params) != 1 {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, string(invalidRequest))
+	}
+
+	txHash := common.HexToHash(params[0]).Hex()
+	heads := loadHeads(s.Db(), chain)
+	var logs [][]byte
+	err = s.Db().Iterate(DbKeyEventLogPrefix(chain, SpaceBeforeExecution, txHash), "", s.Compressed(), func(key string, data []byte) bool {
+		data = decodeValue(s, data)
+		logs = append(logs, withFinality(data, heads))
+		return true
+	})
+	if err != nil {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, err.Error())
+	}
+
+	resp := rpc.NewJsonRpcMessage(req.ID)
+	resp.Result = jsonArray(logs)
+	return resp
+}
+
+const (
+	_defaultPageSize = 100
+	_maxPageSize     = 1000
+)
+
+type userOperationsQuery struct {
+	Sender    string `json:"sender"`
+	Paymaster string `json:"paymaster"`
+	FromBlock string `json:"fromBlock"`
+	ToBlock   string `json:"toBlock"`
+	Cursor    string `json:"cursor"`
+	Limit     int    `json:"limit"`
+}
+
+// eth_getUserOperationsBySender returns the user operations of a sender in
+// block order, a page at a time. The returned cursor is passed back to fetch
+// the next page, it is null on the last page.
+func eth_getUserOperationsBySender(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
+	var params []userOperationsQuery
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil || len(params) != 1 || !common.IsHexAddress(params[0].Sender) {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, string(invalidRequest))
+	}
+	return listUserOperations(s, chain, req, &params[0], SpaceSender, strings.ToLower(params[0].Sender))
+}
+
+// indexer_getPaymasterOperations returns the user operations sponsored by a
+// paymaster in block order, paginated like eth_getUserOperationsBySender.
+func indexer_getPaymasterOperations(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
+	var params []userOperationsQuery
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil || len(params) != 1 || !common.IsHexAddress(params[0].Paymaster) {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, string(invalidRequest))
+	}
+	return listUserOperations(s, chain, req, &params[0], SpacePaymaster, strings.ToLower(params[0].Paymaster))
+}
+
+func listUserOperations(s Rpc, chain string, req *rpc.JsonRpcMessage, query *userOperationsQuery, space, key string) *rpc.JsonRpcMessage {
+	fromBlock, err := parseBlockNumber(query.FromBlock, 0)
+	if err != nil {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32602, "invalid fromBlock: "+query.FromBlock)
+	}
+	toBlock, err := parseBlockNumber(query.ToBlock, math.MaxUint64)
+	if err != nil {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32602, "invalid toBlock: "+query.ToBlock)
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = _defaultPageSize
+	}
+	if limit > _maxPageSize {
+		limit = _maxPageSize
+	}
+
+	prefix := DbKeyEventLogPrefix(chain, space, key)
+	start := DbKeyEventLog(chain, space, key, fromBlock, 0)
+	if query.Cursor != "" {
+		blockNumber, logIndex, err := parseCursor(query.Cursor)
+		if err != nil || blockNumber < fromBlock {
+			return rpc.NewJsonRpcMessageWithError(req.ID, -32602, "invalid cursor: "+query.Cursor)
+		}
+		start = DbKeyEventLog(chain, space, key, blockNumber, logIndex)
+	}
+	end := ""
+	if toBlock < math.MaxUint64 {
+		end = DbKeyEventLog(chain, space, key, toBlock+1, 0)
+	}
+
+	heads := loadHeads(s.Db(), chain)
+	var ops [][]byte
+	var last string
+	var more bool
+	err = s.Db().Iterate(prefix, start, s.Compressed(), func(k string, data []byte) bool {
+		if query.Cursor != "" && k == start {
+			return true
+		}
+		if end != "" && k >= end {
+			return false
+		}
+		if len(ops) >= limit {
+			more = true
+			return false
+		}
+
+		opHash := string(decodeValue(s, data))
+		record := getValue(s, DbKeyEvent(chain, SpaceUserOpEvent, opHash))
+		if record == nil {
+			return true
+		}
+		ops = append(ops, withFinality(record, heads))
+		last = k
+		return true
+	})
+	if err != nil {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, err.Error())
+	}
+
+	var cursor *string
+	if more {
+		next := strings.TrimPrefix(last, prefix)
+		cursor = &next
+	}
+	result, _ := json.Marshal(struct {
+		UserOperations json.RawMessage `json:"userOperations"`
+		Cursor         *string         `json:"cursor"`
+	}{jsonArray(ops), cursor})
+
+	resp := rpc.NewJsonRpcMessage(req.ID)
+	resp.Result = result
+	return resp
+}
+
+// indexer_getPaymasterStats returns the totals of a paymaster, overall and
+// per UTC day within fromDay and toDay.
+func indexer_getPaymasterStats(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
+	var params []struct {
+		Paymaster string `json:"paymaster"`
+		FromDay   string `json:"fromDay"`
+		ToDay     string `json:"toDay"`
+	}
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil || len(params) != 1 || !common.IsHexAddress(params[0].Paymaster) {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, string(invalidRequest))
+	}
+	param := params[0]
+	for _, day := range []string{param.FromDay, param.ToDay} {
+		if _, err := time.Parse(paymasterDayLayout, day); day != "" && err != nil {
+			return rpc.NewJsonRpcMessageWithError(req.ID, -32602, "invalid day: "+day)
+		}
+	}
+
+	paymaster := strings.ToLower(param.Paymaster)
+	total := &PaymasterStats{ActualGasCost: (*hexutil.Big)(new(big.Int))}
+	if data := getValue(s, DbKeyPaymasterStats(chain, paymaster)); data != nil {
+		json.Unmarshal(data, total)
+	}
+
+	prefix := DbKeyPaymasterDayStats(chain, paymaster, "")
+	start := ""
+	if param.FromDay != "" {
+		start = prefix + param.FromDay
+	}
+	days := []*PaymasterStats{}
+	err = s.Db().Iterate(prefix, start, s.Compressed(), func(key string, data []byte) bool {
+		day := strings.TrimPrefix(key, prefix)
+		if param.ToDay != "" && day > param.ToDay {
+			return false
+		}
+		stats := &PaymasterStats{}
+		if json.Unmarshal(decodeValue(s, data), stats) != nil {
+			return true
+		}
+		stats.Day = day
+		days = append(days, stats)
+		return true
+	})
+	if err != nil {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, err.Error())
+	}
+
+	result, _ := json.Marshal(struct {
+		Paymaster string            `json:"paymaster"`
+		Total     *PaymasterStats   `json:"total"`
+		Days      []*PaymasterStats `json:"days"`
+	}{paymaster, total, days})
+
+	resp := rpc.NewJsonRpcMessage(req.ID)
+	resp.Result = result
+	return resp
+}
+
+// eth_getUserOperationsByTransaction returns the bundle of a transaction with
+// its user operations in execution order.
+func eth_getUserOperationsByTransaction(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
+	var params []string
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil || len(params) != 1 {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, string(invalidRequest))
+	}
+
+	data := getValue(s, DbKeyEvent(chain, SpaceBundle, common.HexToHash(params[0]).Hex()))
+	if data == nil {
+		data = []byte("null")
+	} else {
+		data = withFinality(data, loadHeads(s.Db(), chain))
+	}
+
+	resp := rpc.NewJsonRpcMessage(req.ID)
+	resp.Result = data
+	return resp
+}
+
+// eth_getUserOperationByHash returns a user operation in the bundler
+// response shape, or null if it is not indexed.
+func eth_getUserOperationByHash(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
+	var params []string
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil || len(params) != 1 {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, string(invalidRequest))
+	}
+
+	data := getValue(s, DbKeyEvent(chain, SpaceUserOperation, common.HexToHash(params[0]).Hex()))
+	if data == nil {
+		data = []byte("null")
+	} else {
+		data = withFinality(data, loadHeads(s.Db(), chain))
+	}
+
+	resp := rpc.NewJsonRpcMessage(req.ID)
+	resp.Result = data
+	return resp
+}
+
+// eth_getUserOperationReceipt returns the receipt of a user operation with the
+// logs it emitted within its bundle, or null if it is not indexed.
+func eth_getUserOperationReceipt(s Rpc, chain string, req *rpc.JsonRpcMessage) *rpc.JsonRpcMessage {
+	var params []string
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil || len(params) != 1 {
+		return rpc.NewJsonRpcMessageWithError(req.ID, -32000, string(invalidRequest))
+	}
+
+	resp := rpc.NewJsonRpcMessage(req.ID)
+	resp.Result = []byte("null")
+
+	data := getValue(s, DbKeyEvent(chain, SpaceUserOpReceipt, common.HexToHash(params[0]).Hex()))
+	receipt := &UserOperationReceipt{}
+	if data == nil || json.Unmarshal(data, receipt) != nil {
+		return resp
+	}
+
+	// the transaction receipt is stored once per bundle
+	event := getValue(s, DbKeyEvent(chain, SpaceUserOpEvent, receipt.UserOpHash.Hex()))
+	info := struct {
+		TransactionHash common.Hash `json:"transactionHash"`
+	}{}
+	if event == nil || json.Unmarshal(event, &info) != nil {
+		return resp
+	}
+	receipt.Receipt = getValue(s, DbKeyEvent(chain, SpaceReceipt, info.TransactionHash.Hex()))
+	if receipt.Receipt == nil {
+		return resp
+	}
+
+	resp.Result, _ = json.Marshal(receipt)
+	return resp
+}
@@ -0,0 +1,106 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type testRpc struct {
+	db database.KVStore
+}
+
+func (s *testRpc) Db() database.KVStore              { return s.db }
+func (s *testRpc) EntryPoints(chain string) []string { return nil }
+func (s *testRpc) Compressed() bool                  { return false }
+
+type userOperationsPage struct {
+	UserOperations []UserOperationEvent `json:"userOperations"`
+	Cursor         *string              `json:"cursor"`
+}
+
+func getUserOperationsBySender(t *testing.T, s Rpc, query string) (*userOperationsPage, *rpc.JsonrpcError) {
+	req := &rpc.JsonRpcMessage{ID: json.RawMessage("1"), Params: json.RawMessage("[" + query + "]")}
+	resp := eth_getUserOperationsBySender(s, "test", req)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	page := &userOperationsPage{}
+	if err := json.Unmarshal(resp.Result, page); err != nil {
+		t.Fatal(err)
+	}
+	return page, nil
+}
+
+func TestUserOperationsBySender(t *testing.T) {
+	db := memorydb.New()
+	b := newStoreBackend("test", db, false)
+	var ethlogs []types.Log
+	for i := 0; i < 5; i++ {
+		ethlogs = append(ethlogs, userOpLog(t, common.BigToHash(big.NewInt(int64(i+1))), uint64(100+i/2), common.HexToHash("0xa1"), uint(i), true))
+	}
+	other := userOpLog(t, common.HexToHash("0xff"), 101, common.HexToHash("0xa1"), 9, true)
+	other.Topics[2] = common.HexToHash("0x04")
+	saveTestLogs(t, b, append(ethlogs, other)...)
+	s := &testRpc{db: db}
+
+	// pages of two, in block and log order
+	var hashes []common.Hash
+	query := `{"sender":"0x0000000000000000000000000000000000000002","limit":2}`
+	for pages := 1; ; pages++ {
+		page, rpcErr := getUserOperationsBySender(t, s, query)
+		if rpcErr != nil {
+			t.Fatal(rpcErr.Message)
+		}
+		for _, op := range page.UserOperations {
+			hashes = append(hashes, op.UserOpHash)
+		}
+		if page.Cursor == nil {
+			if pages != 3 {
+				t.Fatalf("%v pages, want 3", pages)
+			}
+			break
+		}
+		query = fmt.Sprintf(`{"sender":"0x0000000000000000000000000000000000000002","limit":2,"cursor":%q}`, *page.Cursor)
+	}
+	if len(hashes) != len(ethlogs) {
+		t.Fatalf("%v user operations, want %v", len(hashes), len(ethlogs))
+	}
+	for i, hash := range hashes {
+		if hash != ethlogs[i].Topics[1] {
+			t.Fatalf("user operation %v is %s, want %s", i, hash.Hex(), ethlogs[i].Topics[1].Hex())
+		}
+	}
+
+	// block range
+	page, rpcErr := getUserOperationsBySender(t, s, `{"sender":"0x0000000000000000000000000000000000000002","fromBlock":"0x65","toBlock":"0x65"}`)
+	if rpcErr != nil {
+		t.Fatal(rpcErr.Message)
+	}
+	if len(page.UserOperations) != 2 || page.Cursor != nil {
+		t.Fatalf("%v user operations in block 101, want 2", len(page.UserOperations))
+	}
+
+	for _, cursor := range []string{
+		"zz",
+		"0000000000000064",
+		"0000000000000064:0000000",
+		"000000000000006A:00000000",
+		"0000000000000064:00000000:",
+		"0000000000000064:0000000g",
+		// before fromBlock
+		"0000000000000063:00000000",
+	} {
+		query := fmt.Sprintf(`{"sender":"0x0000000000000000000000000000000000000002","fromBlock":"0x64","cursor":%q}`, cursor)
+		if _, rpcErr := getUserOperationsBySender(t, s, query); rpcErr == nil || rpcErr.Code != -32602 {
+			t.Errorf("cursor %q accepted", cursor)
+		}
+	}
+}
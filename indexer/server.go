@@ -1,14 +1,16 @@
 package indexer
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"sync"
 
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
 	"github.com/BlockPILabs/erc4337_user_operation_indexer/rpc"
-	"github.com/spf13/cast"
 	"google.golang.org/grpc/credentials"
 )
 
@@ -35,8 +37,8 @@ func (s *Server) Db() database.KVStore {
 	return s.db
 }
 
-func (s *Server) EntryPoints() []string {
-	return s.cfg.EntryPoints
+func (s *Server) EntryPoints(chain string) []string {
+	return s.cfg.EntryPointAddresses(chain)
 }
 
 func (s *Server) Compressed() bool {
@@ -70,24 +72,60 @@ func (s *Server) loadTLSCredentials() (credentials.TransportCredentials, error)
 	return serverCert, nil
 }
 
-func (s *Server) Run() error {
+// Run serves the api until ctx is done, then drains the in-flight requests for
+// at most the shutdown timeout.
+func (s *Server) Run(ctx context.Context) error {
 	s.registerHandlers()
-	http.HandleFunc("/", s.handler)
-	http.HandleFunc("/status", s.status)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handler)
+	mux.HandleFunc("/status", s.status)
 	s.logger.Info("api server listen: " + s.cfg.Listen)
 
+	// handlers keep running after a timed out Shutdown, track them so Run
+	// only returns once none of them can touch the store
+	var handlers sync.WaitGroup
+	server := &http.Server{Addr: s.cfg.Listen, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		mux.ServeHTTP(w, r)
+	})}
+	stopped, done := make(chan struct{}), make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+		case <-done:
+			// the server failed to listen
+			return
+		}
+
+		shutdownCtx, cancelFunc := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+		defer cancelFunc()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			s.logger.Warn("api server shutdown", "err", err)
+			server.Close()
+		}
+		handlers.Wait()
+	}()
+
 	var err error
 	if s.cfg.UseTls {
-		err = http.ListenAndServeTLS(s.cfg.Listen, s.cfg.TlsPubKey, s.cfg.TlsPrivateKey, nil)
-		if err != nil {
+		err = server.ListenAndServeTLS(s.cfg.TlsPubKey, s.cfg.TlsPrivateKey)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.logger.Error("https api server listen failed: " + s.cfg.Listen)
 		}
 	} else {
-		err = http.ListenAndServe(s.cfg.Listen, nil)
-		if err != nil {
+		err = server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			s.logger.Error("http api server listen failed: " + s.cfg.Listen)
 		}
 	}
+	if errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+		s.logger.Info("api server stopped")
+		return nil
+	}
 	return err
 }
 
@@ -145,13 +183,38 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) registerHandlers() {
 	s.handlers["eth_getLogsByUserOperation"] = eth_getLogsByUserOperation
 	s.handlers["eth_getLogs"] = eth_getLogs
+	s.handlers["eth_getUserOperationByHash"] = eth_getUserOperationByHash
+	s.handlers["eth_getUserOperationReceipt"] = eth_getUserOperationReceipt
+	s.handlers["indexer_getUserOperationEvents"] = indexer_getUserOperationEvents
+	s.handlers["eth_getUserOperationsBySender"] = eth_getUserOperationsBySender
+	s.handlers["eth_getUserOperationsByTransaction"] = eth_getUserOperationsByTransaction
+	s.handlers["indexer_getPaymasterOperations"] = indexer_getPaymasterOperations
+	s.handlers["indexer_getPaymasterStats"] = indexer_getPaymasterStats
+	s.handlers["indexer_getBeforeExecutionLogs"] = indexer_getBeforeExecutionLogs
 }
 
 type Status struct {
 	Chain       string `json:"chain"`
 	BlockNumber int64  `json:"block_number"`
 	LatestBlock int64  `json:"latest_block"`
-	CatchingUp  bool   `json:"catching_up"`
+	// HeadBlock is the block the chain is indexed up to with its finality
+	HeadBlock      int64 `json:"head_block"`
+	ConfirmedBlock int64 `json:"confirmed_block,omitempty"`
+	SafeBlock      int64 `json:"safe_block"`
+	FinalizedBlock int64 `json:"finalized_block"`
+	BlockRange     int64 `json:"block_range,omitempty"`
+	CatchingUp     bool  `json:"catching_up"`
+	// Degraded tells whether the backend of the chain failed and is being
+	// restarted
+	Degraded bool   `json:"degraded"`
+	Restarts int    `json:"restarts,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	QuorumMismatches int64 `json:"quorum_mismatches,omitempty"`
+
+	Backfill    *BackfillStatus    `json:"backfill,omitempty"`
+	Backends    []BackendStatus    `json:"backends,omitempty"`
+	EntryPoints []EntryPointStatus `json:"entry_points,omitempty"`
 }
 
 func (s *Server) status(w http.ResponseWriter, r *http.Request) {
@@ -160,8 +223,7 @@ func (s *Server) status(w http.ResponseWriter, r *http.Request) {
 	for _, chain := range s.chains {
 		var blockNumber, latestBlock int64
 		if s.readonly {
-			v, _ := s.db.Get(DbKeyStartBlock(chain), false)
-			blockNumber = cast.ToInt64(string(v))
+			blockNumber = storedBlockNumber(s.db, chain, s.cfg.ChainEntryPoints(chain))
 			latestBlock = blockNumber
 		} else {
 			v, ok := gBlockNumberMap.Load(chain)
@@ -174,12 +236,42 @@ func (s *Server) status(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		stats = append(stats, Status{
-			Chain:       chain,
-			BlockNumber: blockNumber,
-			LatestBlock: latestBlock,
-			CatchingUp:  !(blockNumber >= (latestBlock - 5)),
-		})
+		heads := loadHeads(s.db, chain)
+		headBlock := latestBlock
+		if heads.Head > 0 && heads.Head < headBlock {
+			headBlock = heads.Head
+		}
+		stat := Status{
+			Chain:          chain,
+			BlockNumber:    blockNumber,
+			LatestBlock:    latestBlock,
+			HeadBlock:      headBlock,
+			SafeBlock:      heads.Safe,
+			FinalizedBlock: heads.Finalized,
+			ConfirmedBlock: heads.Confirmed,
+			CatchingUp:     !(blockNumber >= (headBlock - 5)),
+		}
+		if health := loadHealth(chain); health.Restarts > 0 {
+			stat.Degraded, stat.Restarts, stat.Error = health.Degraded, health.Restarts, health.Err
+		}
+		if v, ok := gBlockRangeMap.Load(chain); ok {
+			stat.BlockRange = v.(int64)
+		}
+		if v, ok := gQuorumMismatchMap.Load(chain); ok {
+			stat.QuorumMismatches = v.(int64)
+		}
+		if v, ok := gPoolMap.Load(chain); ok {
+			stat.Backends = v.(*clientPool).Status()
+		}
+		if v, ok := gEntryPointsMap.Load(chain); ok {
+			stat.EntryPoints = v.([]EntryPointStatus)
+		}
+		if v, ok := gBackfillMap.Load(chain); ok {
+			backfill := v.(BackfillStatus)
+			stat.Backfill = &backfill
+			stat.CatchingUp = true
+		}
+		stats = append(stats, stat)
 	}
 
 	data, _ := json.Marshal(stats)
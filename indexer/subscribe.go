@@ -0,0 +1,218 @@
+package indexer
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/web3"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	_maxResubscribeInterval = time.Minute
+	// _pushDelay is how long pushed logs wait for more logs of their block
+	_pushDelay = 100 * time.Millisecond
+)
+
+func isWebsocket(url string) bool {
+	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
+}
+
+// wakeUp makes the polling loop run its next round without waiting for the
+// pulling interval.
+func (b *Backend) wakeUp() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// subscribe follows the head of the chain over the websocket backends. New
+// heads wake the polling loop up, and on chains indexed at the latest head the
+// logs of the tailing entry points are saved a block at a time as they are
+// pushed. The polling loop still owns the cursor, so it fills whatever was
+// missed while disconnected.
+func (b *Backend) subscribe(ctx context.Context) {
+	var clients []*web3.Web3
+	for _, cli := range b.web3Clients {
+		if isWebsocket(cli.Url()) {
+			clients = append(clients, cli)
+		}
+	}
+	if len(clients) == 0 {
+		return
+	}
+
+	interval := _retryInterval
+	for idx := 0; ; idx++ {
+		cli := clients[idx%len(clients)]
+		startTime := time.Now()
+		err := b.follow(ctx, cli)
+		if ctx.Err() != nil {
+			return
+		}
+		b.logger.Warn("subscription closed", "err", err, "url", cli.Url(), "chain", b.chain)
+
+		if time.Since(startTime) > _maxResubscribeInterval {
+			interval = _retryInterval
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+		interval *= 2
+		if interval > _maxResubscribeInterval {
+			interval = _maxResubscribeInterval
+		}
+	}
+}
+
+func (b *Backend) follow(ctx context.Context, cli *web3.Web3) error {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
+
+	heads := make(chan *types.Header, 16)
+	headSub, err := cli.Cli().SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return err
+	}
+	defer headSub.Unsubscribe()
+
+	// only the tailing entry points are pushed, the lagging ones are
+	// resubscribed once they join
+	var logs chan types.Log
+	var logSub ethereum.Subscription
+	var logErr <-chan error
+	subscribeLogs := func() error {
+		if logSub != nil {
+			logSub.Unsubscribe()
+		}
+		logs = make(chan types.Log, 1024)
+		param := ethereum.FilterQuery{
+			Addresses: b.tailingEntryPoints(),
+			Topics:    _logTopics,
+		}
+		sub, err := cli.Cli().SubscribeFilterLogs(ctx, param, logs)
+		if err != nil {
+			logSub = nil
+			return err
+		}
+		logSub, logErr = sub, sub.Err()
+		return nil
+	}
+	pushing := b.finality == FinalityLatest && b.confirmations == 0
+	if pushing {
+		if err := subscribeLogs(); err != nil {
+			return err
+		}
+		defer func() {
+			if logSub != nil {
+				logSub.Unsubscribe()
+			}
+		}()
+	}
+
+	// pushed blocks are saved by a worker, so enriching them doesn't hold up
+	// the heads. Blocks it can't keep up with are left to the polling loop.
+	blocks := make(chan []types.Log, 16)
+	worker := sync.WaitGroup{}
+	worker.Add(1)
+	go func() {
+		defer worker.Done()
+		for ethlogs := range blocks {
+			if ctx.Err() != nil {
+				continue
+			}
+			if err := b.pushLogs(ethlogs, cli); err != nil {
+				b.logger.Error("error save pushed logs", "err", err, "block", ethlogs[0].BlockNumber, "chain", b.chain)
+			}
+		}
+	}()
+	defer worker.Wait()
+	defer close(blocks)
+
+	b.logger.Info("subscribed", "url", cli.Url(), "logs", pushing, "chain", b.chain)
+	b.wakeUp()
+
+	// pushed logs are saved a block at a time, like the polling loop does
+	var pending []types.Log
+	var pushTimer <-chan time.Time
+	push := func() {
+		select {
+		case blocks <- pending:
+		default:
+			b.logger.Warn("pushed logs dropped, left to polling", "block", pending[0].BlockNumber, "chain", b.chain)
+		}
+		pending = nil
+		pushTimer = nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case head := <-heads:
+			gLatestBlockMap.Store(b.chain, head.Number.Int64())
+			b.wakeUp()
+		case ethlog := <-logs:
+			if len(pending) > 0 && pending[0].BlockHash != ethlog.BlockHash {
+				push()
+			}
+			pending = append(pending, ethlog)
+			pushTimer = time.After(_pushDelay)
+		case <-pushTimer:
+			push()
+		case <-b.joined:
+			if !pushing {
+				continue
+			}
+			if len(pending) > 0 {
+				push()
+			}
+			if err := subscribeLogs(); err != nil {
+				return err
+			}
+			b.logger.Info("resubscribed logs", "entrypoints", len(b.tailingEntryPoints()), "url", cli.Url(), "chain", b.chain)
+		case err := <-headSub.Err():
+			return err
+		case err := <-logErr:
+			return err
+		}
+	}
+}
+
+// pushLogs saves the pushed logs of a block in one batch.
+func (b *Backend) pushLogs(ethlogs []types.Log, cli *web3.Web3) error {
+	fetched, err := b.enrich(ethlogs, cli)
+	if err != nil {
+		return err
+	}
+
+	b.windowLock.Lock()
+	defer b.windowLock.Unlock()
+
+	window, err := b.loadWindow()
+	if err != nil {
+		return err
+	}
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	batch := b.db.NewBatch()
+	if err := b.saveLogs(batch, fetched, window); err != nil {
+		b.window = nil
+		return err
+	}
+	if err := b.saveWindow(batch); err != nil {
+		b.window = nil
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		b.window = nil
+		return err
+	}
+	return nil
+}
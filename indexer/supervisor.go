@@ -0,0 +1,106 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/log"
+)
+
+const (
+	_minRestartInterval = time.Second
+	_maxRestartInterval = 5 * time.Minute
+)
+
+var (
+	gHealthMap = sync.Map{}
+
+	errBackendExited = errors.New("backend exited")
+)
+
+// ChainHealth is the supervision state of a chain. A chain is degraded from
+// the moment its backend fails until a restarted one indexes a range again.
+type ChainHealth struct {
+	Degraded bool
+	Restarts int
+	Err      string
+}
+
+func loadHealth(chain string) ChainHealth {
+	if v, ok := gHealthMap.Load(chain); ok {
+		return v.(ChainHealth)
+	}
+	return ChainHealth{}
+}
+
+func setDegraded(chain string, err error) {
+	health := loadHealth(chain)
+	health.Degraded = true
+	health.Restarts++
+	health.Err = err.Error()
+	gHealthMap.Store(chain, health)
+}
+
+// setHealthy clears the degraded state of a chain, keeping its restart count.
+func setHealthy(chain string) {
+	health := loadHealth(chain)
+	if !health.Degraded {
+		return
+	}
+	health.Degraded = false
+	health.Err = ""
+	gHealthMap.Store(chain, health)
+}
+
+// recoverError turns a panic into err.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("panic: %v", r)
+		log.Error("recovered panic", "err", *err, "stack", string(debug.Stack()))
+	}
+}
+
+// superviseChain runs the backend of a chain until ctx is done. A backend
+// that fails, or panics, is restarted with an exponential backoff, the other
+// chains and the servers keep running meanwhile.
+func superviseChain(ctx context.Context, cfg *Config, chain ChainCfg, db database.KVStore) error {
+	logger := log.Module("supervisor")
+
+	interval := _minRestartInterval
+	for {
+		startTime := time.Now()
+		err := runChain(ctx, cfg, chain, db)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			err = errBackendExited
+		}
+
+		setDegraded(chain.Chain, err)
+		if time.Since(startTime) > _maxRestartInterval {
+			interval = _minRestartInterval
+		}
+		logger.Error("backend failed, restarting", "err", err, "in", interval, "chain", chain.Chain)
+
+		if !sleep(ctx, interval) {
+			return nil
+		}
+		interval *= 2
+		if interval > _maxRestartInterval {
+			interval = _maxRestartInterval
+		}
+	}
+}
+
+func runChain(ctx context.Context, cfg *Config, chain ChainCfg, db database.KVStore) (err error) {
+	defer recoverError(&err)
+
+	backend := NewBackend(cfg.Headers, cfg.EntryPoints, chain, db, cfg.Compress)
+	return backend.Run(ctx)
+}
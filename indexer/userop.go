@@ -0,0 +1,88 @@
+package indexer
+
+import (
+	"encoding/json"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/entrypoint"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// SpaceUserOperation holds the full user operation of each user operation
+// hash, decoded from the bundle calldata.
+const SpaceUserOperation = "userop"
+
+// UserOperationByHash is the eth_getUserOperationByHash result.
+type UserOperationByHash struct {
+	UserOperation   *entrypoint.UserOperation `json:"userOperation"`
+	EntryPoint      common.Address            `json:"entryPoint"`
+	TransactionHash common.Hash               `json:"transactionHash"`
+	BlockHash       common.Hash               `json:"blockHash"`
+	BlockNumber     hexutil.Uint64            `json:"blockNumber"`
+}
+
+// bundleEntryPoint returns the version of the entry point a bundle
+// transaction calls directly, nil for bundles submitted through another
+// contract.
+func (b *Backend) bundleEntryPoint(tx *bundleTx) *entrypoint.EntryPoint {
+	if tx == nil || tx.To == nil {
+		return nil
+	}
+	return b.versions[*tx.To]
+}
+
+// userOperations decodes the user operations of a bundle transaction once per
+// range. Bundles that can't be decoded decode to nil.
+func (b *Backend) userOperations(fetched *rangeLogs, txHash common.Hash) []*entrypoint.UserOperation {
+	if ops, ok := fetched.userOps[txHash]; ok {
+		return ops
+	}
+
+	var ops []*entrypoint.UserOperation
+	tx := fetched.transactions[txHash]
+	if ep := b.bundleEntryPoint(tx); ep != nil {
+		var err error
+		ops, _, err = ep.DecodeBundle(tx.Input)
+		if err != nil {
+			b.logger.Debug("undecodable bundle", "tx", txHash.Hex(), "version", ep.Version, "err", err, "chain", b.chain)
+		}
+	}
+	fetched.userOps[txHash] = ops
+	return ops
+}
+
+// findUserOperation returns the user operation of a UserOperationEvent. It is
+// matched by hash if the chain id is known, else, or if no hash matches as for
+// EIP-7702 user operations, by sender and nonce.
+func (b *Backend) findUserOperation(ops []*entrypoint.UserOperation, event *UserOperationEvent) *entrypoint.UserOperation {
+	if ep := b.versions[event.EntryPoint]; ep != nil && b.chainId != nil {
+		for _, op := range ops {
+			if ep.UserOpHash(op, event.EntryPoint, b.chainId) == event.UserOpHash {
+				return op
+			}
+		}
+	}
+	for _, op := range ops {
+		if op.Sender == event.Sender && op.Nonce.ToInt().Cmp(event.Nonce.ToInt()) == 0 {
+			return op
+		}
+	}
+	return nil
+}
+
+// userOperationRecord returns the eth_getUserOperationByHash record of a
+// UserOperationEvent, or nil if its user operation is not in the calldata.
+func (b *Backend) userOperationRecord(fetched *rangeLogs, event *UserOperationEvent) []byte {
+	op := b.findUserOperation(b.userOperations(fetched, event.TransactionHash), event)
+	if op == nil {
+		return nil
+	}
+	record, _ := json.Marshal(&UserOperationByHash{
+		UserOperation:   op,
+		EntryPoint:      event.EntryPoint,
+		TransactionHash: event.TransactionHash,
+		BlockHash:       event.BlockHash,
+		BlockNumber:     event.BlockNumber,
+	})
+	return record
+}
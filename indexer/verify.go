@@ -0,0 +1,318 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/golang/snappy"
+)
+
+// verifyWindow is the comparison of the stored user operations of a block
+// range with the chain.
+type verifyWindow struct {
+	BlockRange
+	stored  int
+	chain   int
+	missing []types.Log
+	extra   []types.Log
+}
+
+// Verify compares the user operations stored for [fromBlock, toBlock] with a
+// fresh eth_getLogs and writes the ledger gaps, and the operations missing
+// from or extra in the store, to w. With repair the extra operations are
+// deleted, the windows with missing ones are indexed again and the range is
+// recorded in the ledger. A negative fromBlock starts at the first indexed
+// block, a negative toBlock ends at the last block below the reorg depth.
+func (b *Backend) Verify(ctx context.Context, fromBlock, toBlock int64, repair bool, w io.Writer) error {
+	headBlockNumber, err := b.pool.Heads()
+	if err != nil {
+		return err
+	}
+	safeBlock := int64(headBlockNumber) - b.reorgDepth
+	if toBlock < 0 {
+		toBlock = safeBlock
+	}
+	if toBlock > safeBlock {
+		return fmt.Errorf("block %v is within the reorg depth %v of the head %v, the last block to verify is %v", toBlock, b.reorgDepth, headBlockNumber, safeBlock)
+	}
+
+	ledgers := map[common.Address]indexedRanges{}
+	for _, address := range b.entryPoints {
+		ranges, err := loadRanges(b.db, DbKeyIndexedRanges(b.chain, address.Hex()))
+		if err != nil {
+			return err
+		}
+		ledgers[address] = ranges
+	}
+	fromBlock, ok := verifyStart(fromBlock, ledgers)
+	if !ok {
+		return fmt.Errorf("no indexed ranges recorded for chain %s, set the first block to verify", b.chain)
+	}
+	if fromBlock > toBlock {
+		return fmt.Errorf("invalid block range [%v,%v]", fromBlock, toBlock)
+	}
+
+	for _, address := range b.entryPoints {
+		fmt.Fprintf(w, "entrypoint %s\n", address.Hex())
+		fmt.Fprintf(w, "  indexed: %v\n", ledgers[address])
+		fmt.Fprintf(w, "  gaps in [%v,%v]: %v\n", fromBlock, toBlock, ledgers[address].gaps(fromBlock, toBlock))
+	}
+
+	stored, err := b.storedOps(fromBlock, toBlock)
+	if err != nil {
+		return err
+	}
+	onChain, err := b.chainOps(ctx, fromBlock, toBlock)
+	if err != nil {
+		return err
+	}
+
+	// the report is split in windows of the configured block range, the
+	// fetched ranges follow the adaptive one
+	windows := map[int64]*verifyWindow{}
+	windowOf := func(blockNumber uint64) *verifyWindow {
+		idx := (int64(blockNumber) - fromBlock) / b.blockRange
+		window, ok := windows[idx]
+		if !ok {
+			from := fromBlock + idx*b.blockRange
+			window = &verifyWindow{BlockRange: BlockRange{From: from, To: int64(math.Min(float64(from+b.blockRange-1), float64(toBlock)))}}
+			windows[idx] = window
+		}
+		return window
+	}
+
+	var stale []types.Log
+	var missing, extra int
+	for hash, ethlog := range onChain {
+		window := windowOf(ethlog.BlockNumber)
+		window.chain++
+		existing, ok := stored[hash]
+		if ok && existing.BlockHash == ethlog.BlockHash && existing.Index == ethlog.Index {
+			continue
+		}
+		window.missing = append(window.missing, ethlog)
+		missing++
+		if ok {
+			// indexed at another position, it is removed before being indexed again
+			stale = append(stale, existing)
+		}
+	}
+	for hash, ethlog := range stored {
+		window := windowOf(ethlog.BlockNumber)
+		window.stored++
+		if _, ok := onChain[hash]; !ok {
+			window.extra = append(window.extra, ethlog)
+			stale = append(stale, ethlog)
+			extra++
+		}
+	}
+
+	var sorted []*verifyWindow
+	for _, window := range windows {
+		if len(window.missing) > 0 || len(window.extra) > 0 {
+			sorted = append(sorted, window)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].From < sorted[j].From
+	})
+	for _, window := range sorted {
+		fmt.Fprintf(w, "%s stored %v chain %v\n", window.BlockRange, window.stored, window.chain)
+		for _, ethlog := range window.missing {
+			fmt.Fprintf(w, "  missing %s block %v tx %s\n", ethlog.Topics[1].Hex(), ethlog.BlockNumber, ethlog.TxHash.Hex())
+		}
+		for _, ethlog := range window.extra {
+			fmt.Fprintf(w, "  extra %s block %v tx %s\n", ethlog.Topics[1].Hex(), ethlog.BlockNumber, ethlog.TxHash.Hex())
+		}
+	}
+	fmt.Fprintf(w, "verified [%v,%v]: %v user operations on chain, %v stored, %v missing, %v extra\n", fromBlock, toBlock, len(onChain), len(stored), missing, extra)
+
+	if !repair {
+		if missing > 0 || extra > 0 {
+			return fmt.Errorf("%v missing and %v extra user operations", missing, extra)
+		}
+		return nil
+	}
+
+	b.deferStats = true
+	if err := b.removeOps(stale); err != nil {
+		return err
+	}
+	b.cursorLock.Lock()
+	b.tailing = append([]common.Address(nil), b.entryPoints...)
+	b.cursorLock.Unlock()
+	b.ranged = true
+	for _, window := range sorted {
+		if len(window.missing) == 0 {
+			continue
+		}
+		if err := b.indexWindow(ctx, window.From, window.To); err != nil {
+			return err
+		}
+	}
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+	batch := b.db.NewBatch()
+	if err := b.recordRange(batch, b.entryPoints, fromBlock, toBlock); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "repaired [%v,%v]: %v user operations indexed again, %v removed\n", fromBlock, toBlock, missing, len(stale))
+	return nil
+}
+
+// verifyStart returns the first block to verify, fromBlock if it is set or else
+// the lowest block in the ledgers.
+func verifyStart(fromBlock int64, ledgers map[common.Address]indexedRanges) (int64, bool) {
+	if fromBlock >= 0 {
+		return fromBlock, true
+	}
+	for _, ranges := range ledgers {
+		if len(ranges) > 0 && (fromBlock < 0 || ranges[0].From < fromBlock) {
+			fromBlock = ranges[0].From
+		}
+	}
+	return fromBlock, fromBlock >= 0
+}
+
+// storedOps returns the stored UserOperationEvent logs of the entry points in
+// [fromBlock, toBlock] by user operation hash. The sender keys are ordered by
+// block under each sender, so it seeks to the window of every sender instead
+// of reading all operations of the chain.
+func (b *Backend) storedOps(fromBlock, toBlock int64) (map[common.Hash]types.Log, error) {
+	addresses := map[common.Address]bool{}
+	for _, address := range b.entryPoints {
+		addresses[address] = true
+	}
+
+	// a sender key ends with :<block>:<log index>
+	const suffixLen = 16 + 1 + 8
+	prefix := DbKeyEvent(b.chain, SpaceSender, "")
+	var opHashes []string
+	var decodeErr error
+	for start, done := "", false; !done; {
+		done = true
+		err := b.db.Iterate(prefix, start, b.compress, func(key string, data []byte) bool {
+			if len(key) < len(prefix)+suffixLen+1 {
+				return true
+			}
+			senderPrefix := key[:len(key)-suffixLen]
+			blockNumber, err := strconv.ParseInt(key[len(senderPrefix):len(senderPrefix)+16], 16, 64)
+			if err != nil {
+				return true
+			}
+			if blockNumber < fromBlock {
+				start, done = fmt.Sprintf("%s%016x", senderPrefix, fromBlock), false
+				return false
+			}
+			if blockNumber > toBlock {
+				start, done = database.PrefixEnd(senderPrefix), false
+				return false
+			}
+
+			if b.compress {
+				if data, decodeErr = snappy.Decode(nil, data); decodeErr != nil {
+					decodeErr = fmt.Errorf("error decode db key %s: %w", key, decodeErr)
+					return false
+				}
+			}
+			opHashes = append(opHashes, string(data))
+			return true
+		})
+		if err == nil {
+			err = decodeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ops := map[common.Hash]types.Log{}
+	for _, opHash := range opHashes {
+		key := DbKeyEvent(b.chain, SpaceUserOp, opHash)
+		data, err := b.db.Get(key, b.compress)
+		if err != nil {
+			return nil, fmt.Errorf("error get db key %s: %w", key, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if b.compress {
+			if data, err = snappy.Decode(nil, data); err != nil {
+				return nil, fmt.Errorf("error decode db key %s: %w", key, err)
+			}
+		}
+		var ethlog types.Log
+		if err := json.Unmarshal(data, &ethlog); err != nil {
+			return nil, fmt.Errorf("error decode db key %s: %w", key, err)
+		}
+		blockNumber := int64(ethlog.BlockNumber)
+		if blockNumber >= fromBlock && blockNumber <= toBlock && addresses[ethlog.Address] && isUserOperationEvent(&ethlog) {
+			ops[ethlog.Topics[1]] = ethlog
+		}
+	}
+	return ops, nil
+}
+
+// chainOps fetches the UserOperationEvent logs of the entry points in
+// [fromBlock, toBlock] by user operation hash.
+func (b *Backend) chainOps(ctx context.Context, fromBlock, toBlock int64) (map[common.Hash]types.Log, error) {
+	ops := map[common.Hash]types.Log{}
+	for start := fromBlock; start <= toBlock; {
+		end := int64(math.Min(float64(start+b.rangeSize.Size()-1), float64(toBlock)))
+		cli, err := b.pool.Next(end)
+		var ethlogs []types.Log
+		if err == nil {
+			ethlogs, err = b.fetchLogs(start, end, b.entryPoints, cli)
+		}
+		if err != nil {
+			b.logger.Error(fmt.Sprintf("error verify logs range [%v,%v]", start, end), "err", err, "chain", b.chain)
+			if !sleep(ctx, _retryInterval) {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+
+		for _, ethlog := range ethlogs {
+			if !ethlog.Removed && isUserOperationEvent(&ethlog) {
+				ops[ethlog.Topics[1]] = ethlog
+			}
+		}
+		b.logger.Info(fmt.Sprintf("verify logs range [%v,%v]", start, end), "progress", fmt.Sprintf("%.1f%%", float64(end-fromBlock+1)*100/float64(toBlock-fromBlock+1)), "chain", b.chain)
+		start = end + 1
+	}
+	return ops, nil
+}
+
+// removeOps deletes stored user operations with everything indexed for them,
+// as if their logs were removed by a reorg.
+func (b *Backend) removeOps(ethlogs []types.Log) error {
+	if len(ethlogs) == 0 {
+		return nil
+	}
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	fetched := &rangeLogs{}
+	for _, ethlog := range ethlogs {
+		ethlog.Removed = true
+		fetched.logs = append(fetched.logs, ethlog)
+	}
+	batch := b.db.NewBatch()
+	if err := b.saveLogs(batch, fetched, nil); err != nil {
+		return err
+	}
+	return batch.Write()
+}
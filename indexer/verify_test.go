@@ -0,0 +1,102 @@
+package indexer
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/BlockPILabs/erc4337_user_operation_indexer/database/memorydb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestVerifyStart(t *testing.T) {
+	ledgers := map[common.Address]indexedRanges{
+		common.HexToAddress("0x01"): {{From: 200, To: 299}},
+		common.HexToAddress("0x02"): {{From: 100, To: 149}, {From: 200, To: 299}},
+		common.HexToAddress("0x03"): nil,
+	}
+	cases := []struct {
+		fromBlock int64
+		ledgers   map[common.Address]indexedRanges
+		want      int64
+		ok        bool
+	}{
+		{fromBlock: -1, ledgers: ledgers, want: 100, ok: true},
+		{fromBlock: 250, ledgers: ledgers, want: 250, ok: true},
+		{fromBlock: 50, ledgers: ledgers, want: 50, ok: true},
+		{fromBlock: 0, ledgers: ledgers, want: 0, ok: true},
+		{fromBlock: -1, ledgers: nil, ok: false},
+		{fromBlock: 10, ledgers: nil, want: 10, ok: true},
+	}
+	for _, c := range cases {
+		got, ok := verifyStart(c.fromBlock, c.ledgers)
+		if ok != c.ok || (ok && got != c.want) {
+			t.Errorf("verifyStart(%v) = %v, %v, want %v, %v", c.fromBlock, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestStoredOps(t *testing.T) {
+	b := newStoreBackend("test", memorydb.New(), true)
+	b.entryPoints = []common.Address{common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")}
+
+	blockHash := common.HexToHash("0xa1")
+	var ethlogs = []types.Log{
+		userOpLog(t, common.HexToHash("0x01"), 100, blockHash, 0, true),
+		userOpLog(t, common.HexToHash("0x02"), 150, blockHash, 0, true),
+		userOpLog(t, common.HexToHash("0x03"), 200, blockHash, 0, true),
+	}
+	// a second sender with operations before, in and after the window
+	for _, number := range []uint64{90, 160, 210} {
+		ethlog := userOpLog(t, common.BigToHash(new(big.Int).SetUint64(number)), number, blockHash, 1, true)
+		ethlog.Topics[2] = common.HexToHash("0x04")
+		ethlogs = append(ethlogs, ethlog)
+	}
+	saveTestLogs(t, b, ethlogs...)
+
+	ops, err := b.storedOps(120, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []int
+	for _, ethlog := range ops {
+		blocks = append(blocks, int(ethlog.BlockNumber))
+	}
+	sort.Ints(blocks)
+	if fmt.Sprint(blocks) != "[150 160 200]" {
+		t.Fatalf("stored operations of blocks %v in [120,200], want [150 160 200]", blocks)
+	}
+}
+
+func TestRemoveOpsSharedReceipt(t *testing.T) {
+	b := newStoreBackend("test", memorydb.New(), false)
+	blockHash := common.HexToHash("0xa1")
+	first := userOpLog(t, common.HexToHash("0x01"), 100, blockHash, 0, true)
+	second := userOpLog(t, common.HexToHash("0x02"), 100, blockHash, 1, true)
+	saveTestLogs(t, b, first, second)
+	receiptKey := DbKeyEvent(b.chain, SpaceReceipt, first.TxHash.Hex())
+	if err := b.db.Put(receiptKey, []byte("{}"), false); err != nil {
+		t.Fatal(err)
+	}
+	bundleKey := DbKeyEvent(b.chain, SpaceBundle, first.TxHash.Hex())
+
+	if err := b.removeOps([]types.Log{first}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{receiptKey, bundleKey} {
+		if ok, _ := b.db.Has(key); !ok {
+			t.Fatalf("%s removed with an operation of its transaction left", key)
+		}
+	}
+
+	if err := b.removeOps([]types.Log{second}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{receiptKey, bundleKey} {
+		if ok, _ := b.db.Has(key); ok {
+			t.Fatalf("%s kept after removing all operations of its transaction", key)
+		}
+	}
+}
@@ -160,3 +160,9 @@ func WithContext(ctx context.Context, l Logger) context.Context {
 	ctx = context.WithValue(ctx, k, l)
 	return ctx
 }
+
+// ToStderr sends the log to stderr, for commands writing their output to
+// stdout.
+func ToStderr() {
+	root.SetHandler(log15.StderrHandler)
+}
@@ -1,6 +1,13 @@
 package web3
 
-import "github.com/ethereum/go-ethereum/ethclient"
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
 
 type Web3 struct {
 	url    string
@@ -29,3 +36,36 @@ func (w3 *Web3) Cli() *ethclient.Client {
 func (w3 *Web3) Url() string {
 	return w3.url
 }
+
+// batchSize is the number of calls sent in one json-rpc batch, providers
+// reject or throttle large batches.
+const batchSize = 50
+
+// BatchCall calls method once for each entry of args, in json-rpc batches,
+// and decodes the result of call i into results[i]. Each batch is given its
+// own timeout, so the number of calls doesn't eat into it.
+func (w3 *Web3) BatchCall(ctx context.Context, timeout time.Duration, method string, args [][]any, results []any) error {
+	for start := 0; start < len(args); start += batchSize {
+		end := start + batchSize
+		if end > len(args) {
+			end = len(args)
+		}
+
+		batch := make([]rpc.BatchElem, 0, end-start)
+		for i := start; i < end; i++ {
+			batch = append(batch, rpc.BatchElem{Method: method, Args: args[i], Result: results[i]})
+		}
+		batchCtx, cancelFunc := context.WithTimeout(ctx, timeout)
+		err := w3.client.Client().BatchCallContext(batchCtx, batch)
+		cancelFunc()
+		if err != nil {
+			return err
+		}
+		for _, elem := range batch {
+			if elem.Error != nil {
+				return fmt.Errorf("%s: %w", method, elem.Error)
+			}
+		}
+	}
+	return nil
+}